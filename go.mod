@@ -4,5 +4,7 @@ go 1.15
 
 require (
 	github.com/akamai/AkamaiOPEN-edgegrid-golang v1.1.0
+	github.com/google/uuid v1.1.1
 	github.com/grafana/grafana-plugin-sdk-go v0.86.0
+	github.com/prometheus/client_golang v1.3.0
 )
@@ -17,13 +17,20 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
+	"github.com/akamai/AkamaiOPEN-edgegrid-golang/edgegrid"
+	"github.com/google/uuid"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
@@ -31,26 +38,471 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
-// The datasource front-end sends domainnames (to graph) as a string. OPEN API POST request needs a domainname list.
+// The datasource front-end sends domainnames (to graph) as a comma-separated string. OPEN API POST request needs a domainname list.
 func domainListFromDomain(domainName string) []string {
 	domainName = strings.Replace(domainName, " ", "", -1) // remove spaces
-	domainName = strings.Replace(domainName, ",", "", -1) // remove commas
 
 	var cleanList []string
-	if len(domainName) > 0 {
-		cleanList = append(cleanList, domainName)
+	for _, name := range strings.Split(domainName, ",") {
+		if len(name) > 0 {
+			cleanList = append(cleanList, name)
+		}
 	}
 	return cleanList
 }
 
+// A zone name is sent in URLs and request bodies. Refuse one with characters no domain name has,
+// rather than send a request that is broken or means something else.
+func validateZoneName(zone string) error {
+	if len(zone) == 0 || strings.IndexFunc(zone, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return errors.New("Invalid domain name: " + strconv.Quote(zone))
+	}
+	return nil
+}
+
+// Zone names are case-insensitive. In lowercase, the same zone typed in different cases is queried once, with the same labels.
+func lowercaseZoneNames(zones []string) []string {
+	seen := make(map[string]bool, len(zones))
+	var lowercase []string
+	for _, zone := range zones {
+		zone = strings.ToLower(zone)
+		if !seen[zone] {
+			seen[zone] = true
+			lowercase = append(lowercase, zone)
+		}
+	}
+	return lowercase
+}
+
+// Zone names sent as a JSON array, e.g. ["a.akadns.net", "b.akadns.net"], or as a comma-separated string.
+type zoneNamesJson []string
+
+func (z *zoneNamesJson) UnmarshalJSON(b []byte) error {
+	var zoneNames []string
+	if err := json.Unmarshal(b, &zoneNames); err == nil {
+		*z = nil
+		for _, zoneName := range zoneNames {
+			*z = append(*z, domainListFromDomain(zoneName)...)
+		}
+		return nil
+	}
+
+	var zoneNamesString string
+	if err := json.Unmarshal(b, &zoneNamesString); err != nil {
+		return errors.New("zoneNames must be an array of strings or a comma-separated string")
+	}
+	*z = domainListFromDomain(zoneNamesString)
+	return nil
+}
+
+// Limit the zones to those in the allow-list. Zone names are case-insensitive.
+// A zone that isn't allowed fails the query unless 'drop' is set, in which case it is removed.
+func allowedZonesOnly(zones []string, allowedZones []string, drop bool) ([]string, error) {
+	var allowed []string
+	for _, zone := range zones {
+		isAllowed := false
+		for _, allowedZone := range allowedZones {
+			if strings.EqualFold(zone, allowedZone) {
+				isAllowed = true
+				break
+			}
+		}
+		if isAllowed {
+			allowed = append(allowed, zone)
+		} else if !drop {
+			return nil, errors.New("Zone not allowed by this datasource: " + zone)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return nil, errors.New("None of the zones are allowed by this datasource")
+	}
+	return allowed, nil
+}
+
 // The datasource configuration supplied by the front-end.
 type dataSourceSettingsJson struct {
 	ClientSecret string `json:"clientSecret"`
 	Host         string `json:"host"`
 	AccessToken  string `json:"accessToken"`
 	ClientToken  string `json:"clientToken"`
+	DataDelay    string `json:"dataDelay"` // e.g. "10m". Data newer than this is still incomplete.
+	// The header carrying the batch's correlation ID. Default: X-Correlation-Id
+	CorrelationHeader string `json:"correlationHeader"`
+	// What to do with the most recent, still-filling interval: "keep" (default), "null" or "drop".
+	PartialInterval string `json:"partialInterval"`
+	// As PartialInterval, for the HOUR interval. Default: PartialInterval
+	PartialHourInterval string `json:"partialHourInterval"`
+	// If not empty, the only zones this datasource may query.
+	AllowedZones []string `json:"allowedZones"`
+	// Silently drop zones not in AllowedZones instead of failing the query.
+	DropDisallowedZones bool `json:"dropDisallowedZones"`
+	// The zones of queries that don't name any, e.g. for a single-purpose dashboard. An array or a comma-separated string
+	DefaultZones zoneNamesJson `json:"defaultZones"`
+	// The most data points (rows x series) a query may return. Default: DEFAULT_DATA_POINT_LIMIT
+	DataPointLimit uint `json:"dataPointLimit"`
+	// 'Save & Test' only checks that the configuration is well-formed, without calling the OPEN API.
+	OfflineHealthCheck bool `json:"offlineHealthCheck"`
+	// How far back queries may go, e.g. "30d". At most (and by default) the 90 days for which data is available.
+	MaxLookback string `json:"maxLookback"`
+	// Split longer time ranges into windows of this duration, e.g. "30d", queried one after another.
+	QueryWindow string `json:"queryWindow"`
+	// How the time range is aligned to interval boundaries: "outward" (default), "nearest", "floor" or "ceil".
+	Rounding string `json:"rounding"`
+	// Fail queries that have no data, instead of returning an empty series with a notice.
+	NoDataAsError bool `json:"noDataAsError"`
+	// Headers added to every OPEN API request, e.g. for a corporate gateway. Can't replace the EdgeGrid headers.
+	CustomHeaders map[string]string `json:"customHeaders"`
+	// Timeouts of the OPEN API connection, e.g. "5s". Fail fast on connection problems but allow slow bodies.
+	DialTimeout           string `json:"dialTimeout"`           // Default: 30s
+	TLSHandshakeTimeout   string `json:"tlsHandshakeTimeout"`   // Default: 10s
+	ResponseHeaderTimeout string `json:"responseHeaderTimeout"` // Default: none
+	// The API endpoint region, e.g. "china". The host's domain is replaced by the region's. Default: the host as entered
+	Region string `json:"region"`
+	// The most OPEN API requests per second, over all queries. Requests wait their turn. Default: no limit
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	// Where the credentials come from: "jsonData" (default, the fields above), "env" or "edgerc". See credentials.go
+	CredentialSource  string `json:"credentialSource"`
+	CredentialSection string `json:"credentialSection"` // the env or .edgerc section. Default: "default"
+	EdgercPath        string `json:"edgercPath"`
+	// The largest API response body read. Default: DEFAULT_MAX_RESPONSE_BYTES
+	MaxResponseBytes uint `json:"maxResponseBytes"`
+	// If set, 'Save & Test' also queries a day of this zone's data and warns if it doesn't decode fully within MaxResponseBytes.
+	ResponseSizeProbeZone string `json:"responseSizeProbeZone"`
+	// 'Save & Test' warns if the Grafana server's clock is further off the API's, e.g. "10s". Default: DEFAULT_MAX_CLOCK_SKEW
+	MaxClockSkew string `json:"maxClockSkew"`
+	// Time ranges over this use the HOUR interval, e.g. "2w". Default: 4 weeks, the OPEN API's limit for FIVE_MINUTES
+	HourOnlyAfter string `json:"hourOnlyAfter"`
+	// Time ranges this close under HourOnlyAfter use HOUR too, e.g. "30m" or "0s". Default: DEFAULT_HOUR_ONLY_MARGIN
+	HourOnlyMargin string `json:"hourOnlyMargin"`
+	// Use HOUR if the FIVE_MINUTES datapoints are over this fraction of the panel's max datapoints, e.g. 0.5. Default: 1
+	HourlyFillRatio float64 `json:"hourlyFillRatio"`
+	// Fail a query the API returns at a coarser interval than requested, instead of showing a notice and using the returned interval.
+	IntervalMismatchAsError bool `json:"intervalMismatchAsError"`
+	// If the credentials aren't authorized for any of a query's zones, return no data with a notice instead of failing.
+	AllUnauthorizedAsNotice bool `json:"allUnauthorizedAsNotice"`
+	// Retry a query while its response is cut short, e.g. by a transient network problem, within MaxTotalRetryDuration.
+	RetryTruncatedResponses bool `json:"retryTruncatedResponses"`
+	// The longest wait before a retry, e.g. "5s". Default: DEFAULT_MAX_RETRY_DELAY
+	MaxRetryDelay string `json:"maxRetryDelay"`
+	// How long after a query's first request retries may run, e.g. "20s". Default: DEFAULT_MAX_TOTAL_RETRY_DURATION
+	MaxTotalRetryDuration string `json:"maxTotalRetryDuration"`
+	// Time out each query request after this per estimated row (time range / interval), e.g. "50ms". Default: no timeout
+	RequestTimeoutPerRow string `json:"requestTimeoutPerRow"`
+	// The bounds of the scaled request timeout. Defaults: DEFAULT_MIN_REQUEST_TIMEOUT, DEFAULT_MAX_REQUEST_TIMEOUT
+	MinRequestTimeout string `json:"minRequestTimeout"`
+	MaxRequestTimeout string `json:"maxRequestTimeout"`
+	// How far data usually lags real time, e.g. "30m". A query whose data ends earlier gets a warning. Default: no warning
+	ExpectedDataLag string `json:"expectedDataLag"`
+	// Share cached responses with the other datasources that set it. Only datasources with the same credentials reuse a response.
+	SharedCache bool `json:"sharedCache"`
+	// Don't cache API responses, e.g. to rule the cache out when diagnosing unexpected data.
+	DisableResponseCache bool `json:"disableResponseCache"`
+	// Add the API error's type, instance and request ID to error messages, e.g. for Akamai support. Default: the title only
+	VerboseErrors bool `json:"verboseErrors"`
+	// Use HTTP/1.1 instead of HTTP/2, e.g. behind a proxy that mishandles HTTP/2.
+	DisableHttp2 bool `json:"disableHttp2"`
+	// Graph intervals the API reports as 0 as gaps, to tell "no traffic" from "no data". "N/A" counts stay 0.
+	ZeroAsNull bool `json:"zeroAsNull"`
+	// Hosts tried in order when the host fails, e.g. for disaster recovery. They use the same credentials.
+	BackupHosts []string `json:"backupHosts"`
+	// When to try the next host: "connection" (default) on connection errors, "server" also on 5xx responses.
+	FailoverOn string `json:"failoverOn"`
+	// The API's names of metrics it renamed, e.g. {"hits": "requests"}, until the plugin is updated. Default: the metrics' names
+	FieldMapping map[string]string `json:"fieldMapping"`
+	// Query and label zones in lowercase, whatever case they are entered in. Default: as entered
+	LowercaseZoneNames bool `json:"lowercaseZoneNames"`
+	// After this many consecutive failed requests (connection errors or 5xx) within the window, fail requests
+	// without sending them for the cooldown, then probe the API again. Default: 0, never
+	CircuitBreakerThreshold uint   `json:"circuitBreakerThreshold"`
+	CircuitBreakerWindow    string `json:"circuitBreakerWindow"`   // Default: DEFAULT_CIRCUIT_BREAKER_WINDOW
+	CircuitBreakerCooldown  string `json:"circuitBreakerCooldown"` // Default: DEFAULT_CIRCUIT_BREAKER_COOLDOWN
+	// Added to the names of the series, e.g. "Prod: ", to tell datasources apart. Also to names from the metric name.
+	SeriesNamePrefix string `json:"seriesNamePrefix"`
+	SeriesNameSuffix string `json:"seriesNameSuffix"`
+	// The longest time range a query may have, e.g. "30d". Longer ones fail without calling the API. Default: no limit
+	MaxRangeDuration string `json:"maxRangeDuration"`
+}
+
+// The API domain of each region.
+var regionApiDomains = map[string]string{
+	"global": "luna.akamaiapis.net",
+	"china":  "luna.akamaiapis.net.cn",
+}
+
+// The host with the region's API domain, e.g. akab-xxx.luna.akamaiapis.net.cn for "china".
+// The host may be entered with any region's domain, or only as its akab-xxx credential part.
+func regionalHost(host string, region string) (string, error) {
+	if len(region) == 0 {
+		return host, nil
+	}
+	domain, ok := regionApiDomains[region]
+	if !ok {
+		return "", errors.New("Invalid region: " + region)
+	}
+
+	host = strings.TrimPrefix(host, "https://")
+	for _, regionDomain := range regionApiDomains {
+		host = strings.TrimSuffix(host, "."+regionDomain)
+	}
+	return host + "." + domain, nil
+}
+
+// How to reach the OPEN API, from the datasource configuration.
+func newOpenApiSettings(dss dataSourceSettingsJson, instance *instanceSettings) (openApiSettings, error) {
+	correlationHeader := dss.CorrelationHeader
+	if len(correlationHeader) == 0 {
+		correlationHeader = DEFAULT_CORRELATION_HEADER
+	}
+	if err := validateCustomHeaders(dss.CustomHeaders, correlationHeader); err != nil {
+		return openApiSettings{}, err
+	}
+	host, err := regionalHost(dss.Host, dss.Region)
+	if err != nil {
+		return openApiSettings{}, err
+	}
+
+	var backupHosts []string
+	for _, backupHost := range dss.BackupHosts {
+		backupHost, err := regionalHost(backupHost, dss.Region)
+		if err != nil {
+			return openApiSettings{}, err
+		}
+		backupHosts = append(backupHosts, backupHost)
+	}
+	failoverOn := dss.FailoverOn
+	if len(failoverOn) == 0 {
+		failoverOn = FAILOVER_ON_CONNECTION
+	}
+	if failoverOn != FAILOVER_ON_CONNECTION && failoverOn != FAILOVER_ON_SERVER {
+		return openApiSettings{}, errors.New("Invalid failover: " + dss.FailoverOn)
+	}
+
+	retryPolicy := retryPolicy{maxDelay: DEFAULT_MAX_RETRY_DELAY, maxTotal: DEFAULT_MAX_TOTAL_RETRY_DURATION}
+	if len(dss.MaxRetryDelay) > 0 {
+		retryPolicy.maxDelay, err = time.ParseDuration(dss.MaxRetryDelay)
+		if err != nil || retryPolicy.maxDelay < 0 {
+			return openApiSettings{}, errors.New("Invalid maximum retry delay: " + dss.MaxRetryDelay)
+		}
+	}
+	if len(dss.MaxTotalRetryDuration) > 0 {
+		retryPolicy.maxTotal, err = time.ParseDuration(dss.MaxTotalRetryDuration)
+		if err != nil || retryPolicy.maxTotal <= 0 {
+			return openApiSettings{}, errors.New("Invalid maximum total retry duration: " + dss.MaxTotalRetryDuration)
+		}
+	}
+
+	timeout := requestTimeout{min: DEFAULT_MIN_REQUEST_TIMEOUT, max: DEFAULT_MAX_REQUEST_TIMEOUT}
+	if len(dss.RequestTimeoutPerRow) > 0 {
+		timeout.perRow, err = time.ParseDuration(dss.RequestTimeoutPerRow)
+		if err != nil || timeout.perRow < 0 {
+			return openApiSettings{}, errors.New("Invalid request timeout per row: " + dss.RequestTimeoutPerRow)
+		}
+	}
+	if len(dss.MinRequestTimeout) > 0 {
+		timeout.min, err = time.ParseDuration(dss.MinRequestTimeout)
+		if err != nil || timeout.min <= 0 {
+			return openApiSettings{}, errors.New("Invalid minimum request timeout: " + dss.MinRequestTimeout)
+		}
+	}
+	if len(dss.MaxRequestTimeout) > 0 {
+		timeout.max, err = time.ParseDuration(dss.MaxRequestTimeout)
+		if err != nil || timeout.max < timeout.min {
+			return openApiSettings{}, errors.New("Invalid maximum request timeout: " + dss.MaxRequestTimeout)
+		}
+	}
+
+	maxResponseBytes := int64(dss.MaxResponseBytes)
+	if maxResponseBytes == 0 {
+		maxResponseBytes = DEFAULT_MAX_RESPONSE_BYTES
+	}
+
+	return openApiSettings{
+		clientSecret:            dss.ClientSecret,
+		host:                    host,
+		backupHosts:             backupHosts,
+		failoverOn:              failoverOn,
+		accessToken:             dss.AccessToken,
+		clientToken:             dss.ClientToken,
+		correlationHeader:       correlationHeader,
+		customHeaders:           dss.CustomHeaders,
+		httpClient:              instance.httpClient,
+		responseCache:           instance.responseCache,
+		rateLimiter:             instance.rateLimiter,
+		circuitBreaker:          instance.circuitBreaker,
+		maxResponseBytes:        maxResponseBytes,
+		retryTruncatedResponses: dss.RetryTruncatedResponses,
+		retryPolicy:             retryPolicy,
+		requestTimeout:          timeout,
+		verboseErrors:           dss.VerboseErrors,
+		fieldMapping:            dss.FieldMapping,
+	}, nil
+}
+
+// The datasource settings of queries, parsed once, when the instance is created.
+type querySettings struct {
+	partialInterval     string
+	partialHourInterval string
+	dataDelay           time.Duration
+	expectedDataLag     time.Duration // 0: not reported
+	rounding            Rounding
+	maxLookback         time.Duration
+	thresholds          intervalThresholds
+	maxRangeDuration    time.Duration // 0: no limit
+	queryWindow         time.Duration // 0: the time range isn't split
+	dataPointLimit      uint
 }
 
+func newQuerySettings(dss dataSourceSettingsJson) (querySettings, error) {
+	var err error
+	qs := querySettings{
+		partialInterval: dss.PartialInterval,
+		rounding:        Rounding(dss.Rounding),
+		maxLookback:     NINETY_DAYS,
+		thresholds:      defaultIntervalThresholds,
+		dataPointLimit:  dss.DataPointLimit,
+	}
+
+	if len(qs.partialInterval) == 0 {
+		qs.partialInterval = PARTIAL_INTERVAL_KEEP
+	}
+	if qs.partialInterval != PARTIAL_INTERVAL_KEEP && qs.partialInterval != PARTIAL_INTERVAL_NULL && qs.partialInterval != PARTIAL_INTERVAL_DROP {
+		return qs, errors.New("Invalid partial interval handling: " + qs.partialInterval)
+	}
+
+	// The trailing hour is incomplete for up to an hour, so may be handled differently. Default: as partialInterval
+	qs.partialHourInterval = dss.PartialHourInterval
+	if len(qs.partialHourInterval) == 0 {
+		qs.partialHourInterval = qs.partialInterval
+	}
+	if qs.partialHourInterval != PARTIAL_INTERVAL_KEEP && qs.partialHourInterval != PARTIAL_INTERVAL_NULL && qs.partialHourInterval != PARTIAL_INTERVAL_DROP {
+		return qs, errors.New("Invalid partial hour interval handling: " + qs.partialHourInterval)
+	}
+
+	// The optional data delay is a duration, e.g. "10m" or "1h".
+	if len(dss.DataDelay) > 0 {
+		qs.dataDelay, err = time.ParseDuration(dss.DataDelay)
+		if err != nil || qs.dataDelay < 0 {
+			return qs, errors.New("Invalid data delay: " + dss.DataDelay)
+		}
+	}
+
+	// The optional expected data lag is a duration, e.g. "30m". Larger gaps at the end of the data are reported.
+	if len(dss.ExpectedDataLag) > 0 {
+		qs.expectedDataLag, err = time.ParseDuration(dss.ExpectedDataLag)
+		if err != nil || qs.expectedDataLag <= 0 {
+			return qs, errors.New("Invalid expected data lag: " + dss.ExpectedDataLag)
+		}
+	}
+
+	// How the time range is aligned to interval boundaries.
+	if len(qs.rounding) == 0 {
+		qs.rounding = ROUND_OUTWARD
+	}
+	if qs.rounding != ROUND_OUTWARD && qs.rounding != ROUND_NEAREST && qs.rounding != ROUND_FLOOR && qs.rounding != ROUND_CEIL {
+		return qs, errors.New("Invalid rounding: " + dss.Rounding)
+	}
+
+	// How far back data can be queried.
+	if len(dss.MaxLookback) > 0 {
+		qs.maxLookback, err = parseGrafanaDuration(dss.MaxLookback)
+		if err != nil || qs.maxLookback <= 0 || qs.maxLookback > NINETY_DAYS {
+			return qs, errors.New("Invalid maximum lookback: " + dss.MaxLookback)
+		}
+	}
+
+	// When HOUR is chosen instead of FIVE_MINUTES.
+	if len(dss.HourOnlyAfter) > 0 {
+		hourOnlyAfter, err := parseGrafanaDuration(dss.HourOnlyAfter)
+		if err != nil || hourOnlyAfter < time.Hour {
+			return qs, errors.New("Invalid hour-only time range: " + dss.HourOnlyAfter)
+		}
+		qs.thresholds.hourOnlyHours = uint(hourOnlyAfter.Hours())
+	}
+	if len(dss.HourOnlyMargin) > 0 {
+		hourOnlyMargin, err := parseGrafanaDuration(dss.HourOnlyMargin)
+		if err != nil || hourOnlyMargin < 0 || hourOnlyMargin >= time.Duration(qs.thresholds.hourOnlyHours)*time.Hour {
+			return qs, errors.New("Invalid hour-only margin: " + dss.HourOnlyMargin)
+		}
+		qs.thresholds.hourOnlyMargin = hourOnlyMargin
+	}
+	if dss.HourlyFillRatio < 0 {
+		return qs, fmt.Errorf("Invalid hourly fill ratio: %v", dss.HourlyFillRatio)
+	}
+	if dss.HourlyFillRatio > 0 {
+		qs.thresholds.hourlyFillRatio = dss.HourlyFillRatio
+	}
+
+	// Refuse oversized time ranges before they cost API budget.
+	if len(dss.MaxRangeDuration) > 0 {
+		qs.maxRangeDuration, err = parseGrafanaDuration(dss.MaxRangeDuration)
+		if err != nil || qs.maxRangeDuration <= 0 {
+			return qs, errors.New("Invalid maximum time range: " + dss.MaxRangeDuration)
+		}
+	}
+
+	// Long time ranges are optionally queried in windows, e.g. "30d". A window is at least an interval, checked per query.
+	if len(dss.QueryWindow) > 0 {
+		qs.queryWindow, err = parseGrafanaDuration(dss.QueryWindow)
+		if err != nil || qs.queryWindow <= 0 {
+			return qs, errors.New("Invalid query window: " + dss.QueryWindow)
+		}
+	}
+
+	// The most data points a query may return.
+	if qs.dataPointLimit == 0 {
+		qs.dataPointLimit = DEFAULT_DATA_POINT_LIMIT
+	}
+	return qs, nil
+}
+
+// Check that the EdgeGrid credentials are present and well-formed.
+func validateCredentials(ds dataSourceSettingsJson) error {
+	if len(ds.ClientSecret) == 0 {
+		return errors.New("Enter the client secret")
+	}
+	if len(ds.Host) == 0 {
+		return errors.New("Enter the host")
+	}
+	if len(ds.AccessToken) == 0 {
+		return errors.New("Enter the access token")
+	}
+	if len(ds.ClientToken) == 0 {
+		return errors.New("Enter the client token")
+	}
+
+	// E.g. akab-xxxxxxxxxxxxxxxx-xxxxxxxxxxxxxxxx.luna.akamaiapis.net, optionally with https://
+	host := strings.TrimPrefix(ds.Host, "https://")
+	if !strings.HasPrefix(host, "akab-") || strings.ContainsAny(host, "/ ") {
+		return errors.New("Invalid host: " + ds.Host)
+	}
+	if _, err := regionalHost(ds.Host, ds.Region); err != nil {
+		return err
+	}
+	for _, backupHost := range ds.BackupHosts {
+		if host := strings.TrimPrefix(backupHost, "https://"); !strings.HasPrefix(host, "akab-") || strings.ContainsAny(host, "/ ") {
+			return errors.New("Invalid backup host: " + backupHost)
+		}
+	}
+	if !strings.HasPrefix(ds.AccessToken, "akab-") {
+		return errors.New("Invalid access token: expected it to start with akab-")
+	}
+	if !strings.HasPrefix(ds.ClientToken, "akab-") {
+		return errors.New("Invalid client token: expected it to start with akab-")
+	}
+	if strings.ContainsAny(ds.ClientSecret, " ") {
+		return errors.New("Invalid client secret: contains spaces")
+	}
+	return nil
+}
+
+// Handling of the most recent interval when it is still incomplete.
+const (
+	PARTIAL_INTERVAL_KEEP = "keep" // graph it as reported
+	PARTIAL_INTERVAL_NULL = "null" // graph a gap
+	PARTIAL_INTERVAL_DROP = "drop" // remove it from the graph
+)
+
 // Query information supplied by the front-end
 type dataQueryJson struct {
 	DataSourceId  uint   `json:"dataSourceId"`
@@ -58,21 +510,247 @@ type dataQueryJson struct {
 	MaxDataPoints uint   `json:"maxDataPoints"`
 	DomainName    string `json:"domainName"`
 	MetricName    string `json:"metricName"`
+	// For API clients: the zones as a JSON array, or a comma-separated string. Used instead of DomainName.
+	ZoneNames zoneNamesJson `json:"zoneNames"`
+	// The metrics to graph, in the order their fields are returned. Default: ["hits"]
+	Metrics []string `json:"metrics"`
+	// The report interval as a duration, e.g. "15m" or "1h". Snapped to the nearest supported interval.
+	// Default: chosen from the time range and maxDataPoints.
+	Interval string `json:"interval"`
+	// The name of the time field. Default: "time"
+	TimeFieldName string `json:"timeFieldName"`
+	// Return only the total of each metric over the time range, not the time series.
+	SummaryOnly bool `json:"summaryOnly"`
+	// Return the OPEN API response bodies, unparsed, instead of the time series. For debugging.
+	Raw bool `json:"raw"`
+	// "wide" (default): a field per zone and metric. "long": a row per time and zone, with a zone field.
+	FrameFormat string `json:"frameFormat"`
+	// Also return the API's summary statistics (total, average, peak, etc.) in a separate frame.
+	IncludeSummary bool `json:"includeSummary"`
+	// Fail if the time range starts before the oldest available data, instead of starting at the oldest data.
+	NoClamp bool `json:"noClamp"`
+	// Round values to this many decimal places, e.g. 0 for integer hit counts. Default: not rounded
+	Precision *int `json:"precision"`
+	// How rows with the same time are merged: "last" (default), "sum" or "drop" (keep the first).
+	DuplicateTimes string `json:"duplicateTimes"`
+	// For power users: the OPEN API request body, e.g. with filters. Used instead of the domains and metrics.
+	RequestBody json.RawMessage `json:"requestBody"`
+	// End the time range when the API's available data ends, instead of at the dashboard's end time.
+	AnchorToAvailableData bool `json:"anchorToAvailableData"`
+	// Display names of zones, e.g. {"prod-eu.akadns.net": "Production EU"}, for legends. Zones without one keep the default naming.
+	ZoneAliases map[string]string `json:"zoneAliases"`
+	// Also return each zone's and metric's total over the time range in a separate one-row frame, as for SummaryOnly.
+	IncludeTotal bool `json:"includeTotal"`
+	// Also graph the time range this long earlier, e.g. "7d", lined up with the time range. Its series have an "offset" label.
+	CompareOffset string `json:"compareOffset"`
+	// Graph each zone's percentage of all the zones' total, e.g. for traffic distribution.
+	PercentOfTotal bool `json:"percentOfTotal"`
+	// Also return the API's response metadata (interval, start, end, available data end, row count, etc.) in a separate frame.
+	IncludeMetadata bool `json:"includeMetadata"`
+	// The type of the value fields: "float" (default) or "int", rounded, e.g. for panels expecting whole counts.
+	ValueType string `json:"valueType"`
+	// Show query errors as notices on the frame instead of failing the panel, keeping whatever data arrived.
+	Resilient bool `json:"resilient"`
+	// Also graph each count's running total over the time range, in a field after the count's, e.g. "hits cumulative".
+	Cumulative bool `json:"cumulative"`
+	// Also graph each count as a per-second rate (the count over the interval's seconds), in a field after the count's, e.g. "hits per second".
+	IncludeRate bool `json:"includeRate"`
+	// Re-fetch from the API instead of using cached responses, e.g. after a data correction. The fresh responses are still cached.
+	NoCache bool `json:"noCache"`
+	// Also return each zone's coverage at each time: the percentage of the metrics with a reported value, not "N/A" or a gap.
+	IncludeCoverage bool `json:"includeCoverage"`
+	// Query the last N complete intervals instead of the dashboard's time range, e.g. 24 with interval "1h".
+	LastN uint `json:"lastN"`
+	// How "N/A" counts add to SummaryOnly and IncludeTotal totals: "zero" (default), as 0, or "skip", a total of only "N/A" counts is null.
+	TotalNA string `json:"totalNA"`
 }
 
 // Grafana structures and functions
 func newDataSourceInstance(setting backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	return &instanceSettings{
-		httpClient: &http.Client{},
+	var dss dataSourceSettingsJson
+	err := json.Unmarshal(setting.JSONData, &dss)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveCredentials(&dss); err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHttpClient(dss)
+	if err != nil {
+		return nil, err
+	}
+	if dss.RequestsPerSecond < 0 {
+		return nil, fmt.Errorf("Invalid requests per second: %v", dss.RequestsPerSecond)
+	}
+	responseCache := newResponseCache()
+	if dss.SharedCache {
+		responseCache = sharedResponseCache
+	}
+	if dss.DisableResponseCache {
+		responseCache = nil
+	}
+	circuitBreakerWindow := DEFAULT_CIRCUIT_BREAKER_WINDOW
+	if len(dss.CircuitBreakerWindow) > 0 {
+		circuitBreakerWindow, err = time.ParseDuration(dss.CircuitBreakerWindow)
+		if err != nil || circuitBreakerWindow <= 0 {
+			return nil, errors.New("Invalid circuit breaker window: " + dss.CircuitBreakerWindow)
+		}
+	}
+	circuitBreakerCooldown := DEFAULT_CIRCUIT_BREAKER_COOLDOWN
+	if len(dss.CircuitBreakerCooldown) > 0 {
+		circuitBreakerCooldown, err = time.ParseDuration(dss.CircuitBreakerCooldown)
+		if err != nil || circuitBreakerCooldown <= 0 {
+			return nil, errors.New("Invalid circuit breaker cooldown: " + dss.CircuitBreakerCooldown)
+		}
+	}
+	querySettings, err := newQuerySettings(dss)
+	if err != nil {
+		return nil, err
+	}
+
+	// The clock skew above which the health check warns.
+	maxClockSkew := DEFAULT_MAX_CLOCK_SKEW
+	if len(dss.MaxClockSkew) > 0 {
+		maxClockSkew, err = time.ParseDuration(dss.MaxClockSkew)
+		if err != nil || maxClockSkew <= 0 {
+			return nil, errors.New("Invalid maximum clock skew: " + dss.MaxClockSkew)
+		}
+	}
+
+	instance := &instanceSettings{
+		dss:            dss,
+		query:          querySettings,
+		maxClockSkew:   maxClockSkew,
+		httpClient:     httpClient,
+		responseCache:  responseCache,
+		rateLimiter:    newRateLimiter(dss.RequestsPerSecond),
+		circuitBreaker: newCircuitBreaker(dss.CircuitBreakerThreshold, circuitBreakerWindow, circuitBreakerCooldown),
+	}
+	instance.openApi, err = newOpenApiSettings(dss, instance)
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// The HTTP client for OPEN API requests, with the configured timeouts.
+func newHttpClient(dss dataSourceSettingsJson) (*http.Client, error) {
+	timeouts := map[string]time.Duration{
+		"dial":            30 * time.Second,
+		"TLS handshake":   10 * time.Second,
+		"response header": 0, // no timeout
+	}
+	for name, setting := range map[string]string{
+		"dial":            dss.DialTimeout,
+		"TLS handshake":   dss.TLSHandshakeTimeout,
+		"response header": dss.ResponseHeaderTimeout,
+	} {
+		if len(setting) == 0 {
+			continue
+		}
+		timeout, err := time.ParseDuration(setting)
+		if err != nil || timeout < 0 {
+			return nil, errors.New("Invalid " + name + " timeout: " + setting)
+		}
+		timeouts[name] = timeout
+	}
+
+	// As http.DefaultTransport, but with the configured timeouts.
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeouts["dial"],
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     !dss.DisableHttp2, // HTTP/2 multiplexes concurrent requests over one connection
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   timeouts["TLS handshake"],
+		ResponseHeaderTimeout: timeouts["response header"],
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if dss.DisableHttp2 {
+		// A non-nil empty map stops the transport from negotiating HTTP/2.
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	// Redirected requests must be signed again.
+	host, err := regionalHost(dss.Host, dss.Region)
+	if err != nil {
+		return nil, err
+	}
+	config := NewEdgegridConfig(dss.ClientSecret, host, dss.AccessToken, dss.ClientToken)
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			edgegrid.AddRequestHeader(*config, req)
+			return nil
+		},
 	}, nil
 }
 
+// The datasource instance: its settings, parsed and with the credentials resolved when it is created,
+// and what outlives a request, e.g. the HTTP client and the response cache.
 type instanceSettings struct {
-	httpClient *http.Client
+	dss          dataSourceSettingsJson
+	query        querySettings
+	openApi      openApiSettings // each query's copy has the query's stats and cache bypass
+	maxClockSkew time.Duration
+
+	httpClient     *http.Client
+	responseCache  *responseCache
+	rateLimiter    *rateLimiter
+	circuitBreaker *circuitBreaker
+
+	// The last successful health check. Reused for HEALTH_CHECK_CACHE_TTL. Failures are not cached.
+	healthCheckMu      sync.Mutex
+	healthCheckOkTime  time.Time
+	healthCheckMessage string
+}
+
+// A successful health check is reused for a short while: dashboards may trigger many of them.
+const HEALTH_CHECK_CACHE_TTL = 60 * time.Second
+
+// The message of a successful health check within HEALTH_CHECK_CACHE_TTL, if any.
+func (s *instanceSettings) cachedHealthCheck(now time.Time) (string, bool) {
+	s.healthCheckMu.Lock()
+	defer s.healthCheckMu.Unlock()
+	if s.healthCheckOkTime.IsZero() || now.Sub(s.healthCheckOkTime) > HEALTH_CHECK_CACHE_TTL {
+		return "", false
+	}
+	return s.healthCheckMessage, true
+}
+
+func (s *instanceSettings) cacheHealthCheck(message string, now time.Time) {
+	s.healthCheckMu.Lock()
+	defer s.healthCheckMu.Unlock()
+	s.healthCheckOkTime = now
+	s.healthCheckMessage = message
+}
+
+// A zone's failure, as a notice for resilient mode. The other zones' data is still shown.
+func zoneErrorNotice(zone string, err error) data.Notice {
+	return data.Notice{Severity: data.NoticeSeverityError, Text: "Failed to query " + zone + ": " + err.Error()}
+}
+
+// The failed response's error as a notice on its frame, adding an empty frame if it has none, so the panel doesn't fail.
+func resilientResponse(response backend.DataResponse) backend.DataResponse {
+	if response.Error == nil {
+		return response
+	}
+	if len(response.Frames) == 0 {
+		response.Frames = append(response.Frames, data.NewFrame("response"))
+	}
+	response.Frames[0].AppendNotices(data.Notice{Severity: data.NoticeSeverityError, Text: response.Error.Error()})
+	response.Error = nil
+	return response
 }
 
 // Called before creating a new instance to allow plugin to cleanup.
+// Nothing outlives a request but the client's idle connections: close them.
 func (s *instanceSettings) Dispose() {
+	s.httpClient.CloseIdleConnections()
 }
 
 func newDatasource() datasource.ServeOpts {
@@ -86,8 +764,9 @@ func newDatasource() datasource.ServeOpts {
 	}
 
 	return datasource.ServeOpts{
-		QueryDataHandler:   ds,
-		CheckHealthHandler: ds,
+		QueryDataHandler:    ds,
+		CheckHealthHandler:  ds,
+		CallResourceHandler: newResourceHandler(im),
 	}
 }
 
@@ -103,18 +782,28 @@ func (td *AkamaiEdgeDnsDatasource) QueryData(ctx context.Context, req *backend.Q
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
-	log.DefaultLogger.Info("QueryData", "Login", req.PluginContext.User.Login)
-	log.DefaultLogger.Info("QueryData", "Role", req.PluginContext.User.Role)
+	// Every request in this batch carries the same correlation ID, as does every log line.
+	ctx = withCorrelationId(ctx, uuid.New().String())
+	logger := contextLogger(ctx)
 
-	var dss dataSourceSettingsJson
-	err := json.Unmarshal(req.PluginContext.DataSourceInstanceSettings.JSONData, &dss)
+	// Alerting and service-account queries have no user.
+	if user := req.PluginContext.User; user != nil {
+		logger.Debug("QueryData", "Login", user.Login)
+		logger.Debug("QueryData", "Role", user.Role)
+	} else {
+		logger.Debug("QueryData", "User", "none")
+	}
+
+	// The instance's settings were parsed, and its credentials resolved, when it was created.
+	instance, err := td.im.Get(req.PluginContext)
 	if err != nil {
 		return response, err
 	}
+	settings := instance.(*instanceSettings)
 
 	// loop over queries and execute them individually.
 	for _, q := range req.Queries {
-		res := td.query(ctx, q, dss)
+		res := td.query(ctx, q, settings)
 
 		// save the response in a hashmap
 		// based on with RefID as identifier
@@ -124,120 +813,682 @@ func (td *AkamaiEdgeDnsDatasource) QueryData(ctx context.Context, req *backend.Q
 	return response, nil
 }
 
-func (td *AkamaiEdgeDnsDatasource) query(ctx context.Context, query backend.DataQuery, dss dataSourceSettingsJson) backend.DataResponse {
-	// log.DefaultLogger.Info("QueryData", "clientSecret", dss.ClientSecret)
-	// log.DefaultLogger.Info("QueryData", "host", dss.Host)
-	// log.DefaultLogger.Info("QueryData", "accessToken", dss.AccessToken)
-	// log.DefaultLogger.Info("QueryData", "clientToken", dss.ClientToken)
+// Build the query's requests, fetch its zones' data, and assemble its frames.
+func (td *AkamaiEdgeDnsDatasource) query(ctx context.Context, query backend.DataQuery, instance *instanceSettings) (response backend.DataResponse) {
+	logger := contextLogger(ctx)
+	logger.Debug("QueryData", "RefID", query.RefID)
 
-	log.DefaultLogger.Info("QueryData", "RefID", query.RefID)
+	// One line per query, when it ends, with its performance and error: grep for slow or failing queries.
+	// Deferred before any return, so queries failing early, e.g. with invalid JSON, are logged too.
+	var dqj dataQueryJson
+	stats := &queryStats{start: time.Now()}
+	defer func() {
+		stats.log(logger, query, response)
 
-	response := backend.DataResponse{}
+		// In resilient mode, the panel shows whatever data arrived, with the errors as notices.
+		if dqj.Resilient {
+			response = resilientResponse(response)
+		}
+	}()
 
 	// Unmarshal the (query request input) json into the 'dataQueryJson' structure
-	var dqj dataQueryJson
 	response.Error = json.Unmarshal(query.JSON, &dqj)
 	if response.Error != nil {
 		return response
 	}
 
-	log.DefaultLogger.Info("query", "query.TimeRange.From", query.TimeRange.From)
-	log.DefaultLogger.Info("query", "query.TimeRange.To", query.TimeRange.To)
-	log.DefaultLogger.Info("query", "maxDataPoints", dqj.MaxDataPoints)
-	log.DefaultLogger.Info("query", "domainName", dqj.DomainName)
-	log.DefaultLogger.Info("query", "metricName", dqj.MetricName)
+	req, err := newQueryRequest(query, dqj, instance, logger)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	stats.interval = req.interval
+	stats.zones = len(req.zones)
+
+	// The OPEN API returns the data to graph. The stats and cache bypass are the query's.
+	settings := instance.openApi
+	settings.stats = stats
+	settings.bypassCache = dqj.NoCache
 
-	// If DomainName is empty then ignore the query
-	if len(dqj.DomainName) == 0 {
-		response.Error = errors.New("Enter a domain name")
+	// For power users: a request body of their own, e.g. with filters. The response's columns become fields.
+	if req.requestBody != nil {
+		frame, err := fetchRequestBodyFrame(ctx, settings, req, instance.dss)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		response.Frames = append(response.Frames, frame)
 		return response
+	}
 
+	// Show the freshest complete data: end the time range when the available data ends, keeping its length.
+	if dqj.AnchorToAvailableData {
+		if err := req.anchorToAvailableData(ctx, settings, instance.query); err != nil {
+			response.Error = err
+			return response
+		}
 	}
 
-	// 'interval' and fixed-up 'from' and 'to' times are needed to make the OPEN API POST URL
-	interval := calculateInterval(query.TimeRange.From, query.TimeRange.To, dqj.MaxDataPoints)
-	fromRounded, toRounded, err := adjustQueryTimes(query.TimeRange.From, query.TimeRange.To, interval)
+	// For debugging: the OPEN API response body for each zone, unparsed.
+	if dqj.Raw {
+		frame, err := fetchRawFrame(ctx, settings, req)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		response.Frames = append(response.Frames, frame)
+		return response
+	}
+
+	zones, err := fetchZones(ctx, settings, req, instance)
 	if err != nil {
 		response.Error = err
 		return response
 	}
+	response.Frames = assembleFrames(req, zones, instance.dss, logger)
+	return response
+}
+
+// A query's requests to the OPEN API, and how to build its frames: the query, validated against the datasource's settings.
+type queryRequest struct {
+	dqj            dataQueryJson
+	from           time.Time // the time range, after lastN and anchoring. The compareOffset time range is offset from it
+	to             time.Time
+	fromRounded    time.Time // the time range requested, rounded to the interval
+	toRounded      time.Time
+	interval       Interval
+	zones          []string                          // each requested, and graphed, separately
+	metrics        []string                          // in the user's order
+	requestBody    *GtmDnsTrafficAllPropertiesReqDto // for power users, instead of the zones and metrics
+	compareOffset  time.Duration                     // 0: no earlier time range
+	queryWindow    time.Duration                     // 0: the time range isn't split
+	duplicateTimes string
+	totalNA        string
+	timeFieldName  string
+	customMeta     map[string]interface{} // datasource-specific frame metadata, e.g. for the query inspector
+	sentRequests   []string               // each request sent, for the query inspector, to diagnose unexpected data
+	notices        []data.Notice          // information for the user about how the query was handled
+}
+
+// Validate the query and work out its interval, time range, zones and metrics. Nothing is requested yet.
+func newQueryRequest(query backend.DataQuery, dqj dataQueryJson, instance *instanceSettings, logger log.Logger) (*queryRequest, error) {
+	dss := instance.dss
+	qs := instance.query
+	req := &queryRequest{dqj: dqj, from: query.TimeRange.From, to: query.TimeRange.To}
+
+	// If DomainName is empty, and the datasource has no default zones, then ignore the query
+	if len(dqj.DomainName) == 0 && len(dqj.ZoneNames) == 0 && len(dqj.RequestBody) == 0 && len(dss.DefaultZones) == 0 {
+		return nil, errors.New("Enter a domain name")
+	}
+
+	// Decimal places to round values to, if any.
+	if dqj.Precision != nil && (*dqj.Precision < 0 || *dqj.Precision > MAX_PRECISION) {
+		return nil, fmt.Errorf("Invalid precision: %v", *dqj.Precision)
+	}
+
+	// How rows with the same time are merged.
+	req.duplicateTimes = dqj.DuplicateTimes
+	if len(req.duplicateTimes) == 0 {
+		req.duplicateTimes = DUPLICATE_TIMES_LAST
+	}
+	if req.duplicateTimes != DUPLICATE_TIMES_LAST && req.duplicateTimes != DUPLICATE_TIMES_SUM && req.duplicateTimes != DUPLICATE_TIMES_DROP {
+		return nil, errors.New("Invalid duplicate times: " + dqj.DuplicateTimes)
+	}
+
+	// How "N/A" counts add to totals.
+	req.totalNA = dqj.TotalNA
+	if len(req.totalNA) == 0 {
+		req.totalNA = TOTAL_NA_ZERO
+	}
+	if req.totalNA != TOTAL_NA_ZERO && req.totalNA != TOTAL_NA_SKIP {
+		return nil, errors.New("Invalid total N/A handling: " + dqj.TotalNA)
+	}
+
+	// The type of the value fields.
+	if dqj.ValueType != "" && dqj.ValueType != VALUE_TYPE_FLOAT && dqj.ValueType != VALUE_TYPE_INT {
+		return nil, errors.New("Invalid value type: " + dqj.ValueType)
+	}
+
+	// The frame's layout, checked before any data is requested.
+	if dqj.FrameFormat != "" && dqj.FrameFormat != FRAME_FORMAT_WIDE && dqj.FrameFormat != FRAME_FORMAT_LONG {
+		return nil, errors.New("Invalid frame format: " + dqj.FrameFormat)
+	}
+
+	// 'interval' and fixed-up 'from' and 'to' times are needed to make the OPEN API POST URL
+	var intervalReason string
+	if len(dqj.Interval) == 0 {
+		req.interval, intervalReason = calculateInterval(req.from, req.to, dqj.MaxDataPoints, qs.thresholds, logger)
+	} else {
+		requested, err := parseGrafanaDuration(dqj.Interval)
+		if err != nil || requested <= 0 {
+			return nil, errors.New("Invalid interval: " + dqj.Interval)
+		}
+		var snapped string
+		req.interval, snapped = intervalFromDuration(requested, req.from, req.to, qs.thresholds)
+		intervalReason = "requested interval " + dqj.Interval
+		if len(snapped) > 0 {
+			logger.Debug("query", "interval", req.interval, "notice", snapped)
+			req.notices = append(req.notices, data.Notice{Severity: data.NoticeSeverityInfo, Text: snapped})
+			intervalReason = snapped
+		}
+	}
+	logger.Debug("query", "interval", req.interval, "intervalReason", intervalReason)
+
+	// The last N complete intervals replace the dashboard's time range, so the trailing edge doesn't flicker.
+	if dqj.LastN > 0 {
+		req.from, req.to = lastIntervalsTimeRange(dqj.LastN, req.interval, timeNow(), qs.dataDelay)
+		logger.Debug("query", "lastN", dqj.LastN, "from", req.from, "to", req.to)
+	}
+
+	// Refuse oversized time ranges before they cost API budget, e.g. a dashboard set to the last 2 years.
+	if qs.maxRangeDuration > 0 {
+		if rangeDuration := req.to.Sub(req.from); rangeDuration > qs.maxRangeDuration {
+			return nil, fmt.Errorf("Time range too long: at most %v is allowed. Narrow the time range", formatLookback(qs.maxRangeDuration))
+		}
+	}
+
+	// Datasource-specific frame metadata, e.g. for the query inspector.
+	req.customMeta = map[string]interface{}{
+		"interval":       req.interval,
+		"intervalReason": intervalReason,
+	}
+	var err error
+	req.fromRounded, req.toRounded, err = adjustQueryTimes(req.from, req.to, req.interval, qs.dataDelay, qs.maxLookback, qs.rounding, dqj.NoClamp, logger)
+	if err != nil {
+		return nil, err
+	}
+	fromRounding, _ := timeRangeRounding(qs.rounding)
+	if requestedFrom, _ := roundupTimeForInterval(req.from, req.interval, fromRounding); req.fromRounded.After(requestedFrom) {
+		// Counted here, once per query: adjustQueryTimes also runs for the anchored and compared time ranges.
+		queryAdjustmentsTotal.WithLabelValues(ADJUSTED_RETENTION_CLAMP).Inc()
+		req.notices = append(req.notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Data is available for the last %v. Showing data from %v.", formatLookback(qs.maxLookback), req.fromRounded.Format(time.RFC3339)),
+		})
+	}
+
+	req.timeFieldName = dqj.TimeFieldName
+	if len(req.timeFieldName) == 0 {
+		req.timeFieldName = "time"
+	}
+
+	if len(dqj.RequestBody) > 0 {
+		if err := req.setRequestBody(dqj.RequestBody, dss, qs, logger); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
 
 	// 'domainNameList' is needed for the OPEN API POST body
 	domainNameList := domainListFromDomain(dqj.DomainName)
+	if len(dqj.ZoneNames) > 0 {
+		domainNameList = dqj.ZoneNames
+	}
 	if len(domainNameList) == 0 {
-		response.Error = errors.New("Enter one domain name")
-		return response
+		domainNameList = dss.DefaultZones
+	}
+	if len(domainNameList) == 0 {
+		return nil, errors.New("Enter one or more domain names")
+	}
+	for _, zone := range domainNameList {
+		if err := validateZoneName(zone); err != nil {
+			return nil, err
+		}
+	}
+	if dss.LowercaseZoneNames {
+		domainNameList = lowercaseZoneNames(domainNameList)
+	}
+
+	// A datasource may be limited to a fixed set of zones, e.g. for tenant isolation.
+	if len(dss.AllowedZones) > 0 {
+		domainNameList, err = allowedZonesOnly(domainNameList, dss.AllowedZones, dss.DropDisallowedZones)
+		if err != nil {
+			logger.Debug("query", "err", err)
+			return nil, err
+		}
+	}
+	req.zones = domainNameList
+
+	// The requested metrics, in the user's order.
+	req.metrics = dqj.Metrics
+	if len(req.metrics) == 0 {
+		req.metrics = []string{DEFAULT_METRIC}
+	}
+	for _, metric := range req.metrics {
+		if len(metric) == 0 || metric == START_DATE_TIME_METRIC {
+			return nil, errors.New("Invalid metric: " + metric)
+		}
+	}
+
+	// An earlier period to compare with, e.g. "7d" for week-over-week.
+	if len(dqj.CompareOffset) > 0 {
+		req.compareOffset, err = parseGrafanaDuration(dqj.CompareOffset)
+		if err != nil || req.compareOffset <= 0 {
+			return nil, errors.New("Invalid compare offset: " + dqj.CompareOffset)
+		}
 	}
 
-	// The OPEN API returns the data to graph.
-	openApiRspDto, err := gtmOpenApiQuery(domainNameList, fromRounded, toRounded, interval, dss.ClientSecret, dss.Host, dss.AccessToken, dss.ClientToken)
+	// Refuse a query whose frame would be too big for the browser to render.
+	numSeries := len(req.zones) * len(req.metrics)
+	if req.compareOffset > 0 {
+		numSeries *= 2
+	}
+	if err := checkDataPointLimit(req.fromRounded, req.toRounded, req.interval, numSeries, qs.dataPointLimit, logger); err != nil && !dqj.SummaryOnly {
+		return nil, err
+	}
+
+	// Long time ranges are optionally queried in windows, e.g. "30d".
+	if qs.queryWindow > 0 && qs.queryWindow < req.interval.Duration() {
+		return nil, errors.New("Invalid query window: " + dss.QueryWindow)
+	}
+	req.queryWindow = qs.queryWindow
+	return req, nil
+}
+
+// Use the power user's request body, e.g. with filters, instead of the zones and metrics.
+func (req *queryRequest) setRequestBody(requestBody json.RawMessage, dss dataSourceSettingsJson, qs querySettings, logger log.Logger) error {
+	reqDto, err := parseRequestBody(requestBody)
 	if err != nil {
-		response.Error = err
-		return response
+		return err
+	}
+	for _, zone := range reqDto.ObjectIds {
+		if err := validateZoneName(zone); err != nil {
+			return err
+		}
+	}
+	if len(dss.AllowedZones) > 0 {
+		reqDto.ObjectIds, err = allowedZonesOnly(reqDto.ObjectIds, dss.AllowedZones, dss.DropDisallowedZones)
+		if err != nil {
+			return err
+		}
 	}
 
-	// The number of datapoints in the response
-	numDataRows := len(openApiRspDto.Data)
-	log.DefaultLogger.Info("query", "numDataRows", numDataRows)
+	// The API aggregates the objects: a column per metric, besides the time.
+	if err := checkDataPointLimit(req.fromRounded, req.toRounded, req.interval, len(reqDto.Metrics)-1, qs.dataPointLimit, logger); err != nil {
+		return err
+	}
 
-	// Create slices that will be added to the dataframe.
-	sampletime := make([]time.Time, numDataRows)
-	hitspersec := make([]float64, numDataRows)
+	// Columns are named like other queries' fields: the fieldMapping renames a metric's API name to the plugin's.
+	for _, requested := range reqDto.Metrics {
+		for metric, field := range dss.FieldMapping {
+			if requested == field && metric != field {
+				req.notices = append(req.notices, data.Notice{
+					Severity: data.NoticeSeverityInfo,
+					Text:     fmt.Sprintf("The %v column is returned as %v, as the datasource's field mapping renames it", field, metric),
+				})
+			}
+		}
+	}
 
-	// The response contains data for 'hits'.
+	req.requestBody = reqDto
+	req.zones = reqDto.ObjectIds
+	return nil
+}
 
-	// Loop through the OPEN API response. Put data items into the dataframe slices.
-	for i, datum := range openApiRspDto.Data {
-		unixms, err := strconv.ParseInt(datum.StartDateTime, 10, 64)
+// End the time range when the available data ends, keeping its length.
+// The anchored time range replaces the dashboard's, also for the compareOffset time range.
+func (req *queryRequest) anchorToAvailableData(ctx context.Context, settings openApiSettings, qs querySettings) error {
+	logger := contextLogger(ctx)
+	availableDataEnds, err := gtmOpenApiAvailableDataEnds(ctx, settings, req.zones[0])
+	if err != nil {
+		return err
+	}
+	req.customMeta["availableDataEnds"] = availableDataEnds
+	if !req.to.After(availableDataEnds) {
+		return nil
+	}
+
+	shift := req.to.Sub(availableDataEnds)
+	req.from, req.to = req.from.Add(-shift), availableDataEnds
+	req.fromRounded, req.toRounded, err = adjustQueryTimes(req.from, req.to, req.interval, qs.dataDelay, qs.maxLookback, qs.rounding, req.dqj.NoClamp, logger)
+	if err != nil {
+		return err
+	}
+	req.notices = append(req.notices, data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("Showing the time range ending when the available data ends, %v.", availableDataEnds.Format(time.RFC3339)),
+	})
+	return nil
+}
+
+// The power user's request body's response, a field per column.
+func fetchRequestBodyFrame(ctx context.Context, settings openApiSettings, req *queryRequest, dss dataSourceSettingsJson) (*data.Frame, error) {
+	requestString := openApiRequestString(req.requestBody, req.fromRounded, req.toRounded, req.interval)
+	req.customMeta["requests"] = []string{requestString}
+	openApiRspDto, err := gtmOpenApiQueryBody(ctx, settings, req.requestBody, req.fromRounded, req.toRounded, req.interval)
+	if errors.Is(err, ErrNoData) && !dss.NoDataAsError {
+		req.notices = append(req.notices, data.Notice{Severity: data.NoticeSeverityInfo, Text: "No data in the time range"})
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if openApiRspDto != nil {
+		openApiRspDto.unmapFields(settings.fieldMapping, req.requestBody.Metrics, contextLogger(ctx))
+	}
+	frame, err := columnsFrame(openApiRspDto, req.timeFieldName)
+	if err != nil {
+		return nil, err
+	}
+	frame.Meta = &data.FrameMeta{Custom: req.customMeta, ExecutedQueryString: requestString}
+	if len(req.notices) > 0 {
+		frame.AppendNotices(req.notices...)
+	}
+	return frame, nil
+}
+
+// For debugging: the OPEN API response body for each zone, unparsed.
+func fetchRawFrame(ctx context.Context, settings openApiSettings, req *queryRequest) (*data.Frame, error) {
+	var bodies []string
+	for _, zone := range req.zones {
+		openApiRspDto, err := gtmOpenApiQuery(ctx, settings, []string{zone}, req.metrics, req.fromRounded, req.toRounded, req.interval)
+		if err != nil && !errors.Is(err, ErrNoData) {
+			return nil, err
+		}
+		bodies = append(bodies, string(openApiRspDto.RawBody))
+	}
+	return data.NewFrame("raw", data.NewField("body", nil, bodies)), nil
+}
+
+// Each zone's data over the time range, then over the compareOffset time range, if any.
+func fetchZones(ctx context.Context, settings openApiSettings, req *queryRequest, instance *instanceSettings) ([]*zoneData, error) {
+	zones, authorized, err := fetchPeriodZones(ctx, settings, req, instance, req.zones, req.fromRounded, req.toRounded, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// The same time range an offset earlier, e.g. last week, re-based to line up with the time range.
+	// Zones the credentials aren't authorized for were already reported for the time range: they aren't queried again.
+	if req.compareOffset > 0 && len(authorized) > 0 {
+		qs := instance.query
+		compareFrom, compareTo, err := adjustQueryTimes(req.from.Add(-req.compareOffset), req.to.Add(-req.compareOffset),
+			req.interval, qs.dataDelay, qs.maxLookback, qs.rounding, req.dqj.NoClamp, contextLogger(ctx))
 		if err != nil {
-			log.DefaultLogger.Error("Error parsing time", "err", err)
-			response.Error = err
-			return response
+			return nil, err
+		}
+		compared, _, err := fetchPeriodZones(ctx, settings, req, instance, authorized, compareFrom, compareTo, req.compareOffset)
+		if err != nil {
+			return nil, err
 		}
-		sampletime[i] = time.Unix(unixms/1000, 0)
+		zones = append(zones, compared...)
+	}
+	return zones, nil
+}
+
+// Each zone's data over the time range fromRounded to toRounded, 'offset' earlier than the query's (0: the query's own),
+// re-based to line up with the query's. Also returns the zones the credentials are authorized for.
+// The OPEN API aggregates the zones in a request. Request each zone separately to graph it separately.
+func fetchPeriodZones(ctx context.Context, settings openApiSettings, req *queryRequest, instance *instanceSettings, zoneNames []string,
+	fromRounded time.Time, toRounded time.Time, offset time.Duration) ([]*zoneData, []string, error) {
+	logger := contextLogger(ctx)
+	dss := instance.dss
+	qs := instance.query
+	dqj := req.dqj
 
-		// Ignore the error. Some data will be "N/A", in which case hits will be zero.
-		hitspersec[i], _ = strconv.ParseFloat(datum.Hits, 64)
+	// Notices name the period, e.g. "example.akadns.net 7d earlier".
+	period := "in the time range"
+	if offset > 0 {
+		period = dqj.CompareOffset + " earlier"
+	}
+
+	var zones []*zoneData
+	var authorized []string
+	var unauthorizedErrs []error
+	for _, zone := range zoneNames {
+		zoneLabel := zone
+		if offset > 0 {
+			zoneLabel = zone + " " + period
+		}
+
+		reqDto := NewGtmDnsTrafficAllPropertiesReqDto([]string{zone}, req.metrics)
+		mapRequestMetrics(reqDto, settings.fieldMapping)
+		openApiRspDto, err := gtmOpenApiQueryInWindows(reqDto, fromRounded, toRounded, req.interval, req.queryWindow, &req.sentRequests, logger,
+			func(windowFrom time.Time, windowTo time.Time) (*GtmDnsTrafficAllPropertiesRspDto, error) {
+				return gtmOpenApiQuery(ctx, settings, []string{zone}, req.metrics, windowFrom, windowTo, req.interval)
+			})
+		// No data is shown as an empty series with a notice, unless the user prefers an error.
+		if errors.Is(err, ErrNoData) && !dss.NoDataAsError {
+			req.notices = append(req.notices, data.Notice{Severity: data.NoticeSeverityInfo, Text: "No data for " + zone + " " + period})
+			err = nil
+		}
+		// Unauthorized zones fail the query, unless all of them are and the user prefers a notice. See below.
+		if errors.Is(err, ErrUnauthorizedObjects) {
+			unauthorizedErrs = append(unauthorizedErrs, err)
+			continue
+		}
+		authorized = append(authorized, zone)
+		if err != nil && dqj.Resilient {
+			req.notices = append(req.notices, zoneErrorNotice(zoneLabel, err))
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if mismatch := objectIdsMismatch([]string{zone}, openApiRspDto.Metadata.ObjectIds); len(mismatch) > 0 {
+			req.notices = append(req.notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: mismatch})
+		}
+
+		zd, err := newZoneData(zone, openApiRspDto, req.metrics, dss.ZeroAsNull, req.interval, logger)
+		if err != nil && dqj.Resilient {
+			req.notices = append(req.notices, zoneErrorNotice(zoneLabel, err))
+			continue
+		}
+		if err != nil {
+			logger.Error("Error parsing time", "err", err)
+			return nil, nil, err
+		}
+
+		zd.alias = zoneAlias(zone, dqj.ZoneAliases)
+
+		// The API may return a coarser interval than requested. The zone's calculations use the interval returned.
+		if zd.interval != req.interval {
+			msg := fmt.Sprintf("The API returned %v data for %v instead of the requested %v", zd.interval, zoneLabel, req.interval)
+			logger.Warn("query", "zone", zoneLabel, "requested", req.interval, "returned", zd.interval)
+			if dss.IntervalMismatchAsError {
+				return nil, nil, errors.New(msg)
+			}
+			req.notices = append(req.notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: msg})
+		}
+
+		// Grafana's time axis needs each time once.
+		zd.mergeDuplicateTimes(req.duplicateTimes, logger)
+
+		// The most recent interval may still be filling, reporting artificially low hits.
+		// Data within the data delay is still being collected, even for an interval that has ended.
+		if zd.interval == HOUR {
+			zd.handlePartialInterval(zd.interval, qs.partialHourInterval, timeNow().Add(-qs.dataDelay), logger)
+		} else {
+			zd.handlePartialInterval(zd.interval, qs.partialInterval, timeNow().Add(-qs.dataDelay), logger)
+		}
+
+		if dqj.Precision != nil {
+			zd.roundValues(*dqj.Precision)
+		}
+
+		// Explain a graph that stops short of the end of the time range by more than usual.
+		if numDataRows := len(zd.sampletime); qs.expectedDataLag > 0 && numDataRows > 0 {
+			dataEnd := zd.sampletime[numDataRows-1].Add(zd.interval.Duration())
+			rangeEnd := toRounded
+			if now := timeNow(); now.Before(rangeEnd) {
+				rangeEnd = now
+			}
+			if lag := rangeEnd.Sub(dataEnd); lag > qs.expectedDataLag {
+				req.notices = append(req.notices, data.Notice{
+					Severity: data.NoticeSeverityWarning,
+					Text: fmt.Sprintf("Data for %v ends at %v, %v before the end of the time range. Data usually lags by at most %v.",
+						zoneLabel, dataEnd.Format(time.RFC3339), lag, qs.expectedDataLag),
+				})
+			}
+		}
+
+		if offset > 0 {
+			zd.compareOffset = dqj.CompareOffset
+			zd.shiftTimes(offset)
+		}
+		zones = append(zones, zd)
+	}
+
+	// So a multi-panel dashboard doesn't look broken, a panel for zones the credentials can't see may be empty.
+	if len(unauthorizedErrs) > 0 {
+		allUnauthorized := len(unauthorizedErrs) == len(zoneNames)
+		switch {
+		case allUnauthorized && dss.AllUnauthorizedAsNotice:
+			req.notices = append(req.notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     "The credentials aren't authorized for any of the zones: " + strings.Join(zoneNames, ", "),
+			})
+		case dqj.Resilient:
+			for _, err := range unauthorizedErrs {
+				req.notices = append(req.notices, data.Notice{Severity: data.NoticeSeverityError, Text: err.Error()})
+			}
+		default:
+			return nil, nil, unauthorizedErrs[0]
+		}
+	}
+	return zones, authorized, nil
+}
+
+// The query's frames from its zones' data: the time series, or only the totals, and the frames asked for alongside.
+func assembleFrames(req *queryRequest, zones []*zoneData, dss dataSourceSettingsJson, logger log.Logger) []*data.Frame {
+	dqj := req.dqj
+
+	// Each request sent, per zone and window, including the compareOffset time range's.
+	req.customMeta["requests"] = req.sentRequests
+	executedQueryString := strings.Join(req.sentRequests, "\n\n")
+
+	// A single row with each metric's total, e.g. for a stat panel.
+	if dqj.SummaryOnly {
+		frame := summaryOnlyFrame(zones, req.metrics, dqj.MetricName, dqj.PercentOfTotal, req.totalNA)
+		affixSeriesNames(frame, dss.SeriesNamePrefix, dss.SeriesNameSuffix)
+		if dqj.ValueType == VALUE_TYPE_INT {
+			integerValueFields(frame)
+		}
+		frame.Meta = &data.FrameMeta{Custom: req.customMeta, ExecutedQueryString: executedQueryString}
+		if len(req.notices) > 0 {
+			frame.AppendNotices(req.notices...)
+		}
+		return []*data.Frame{frame}
+	}
+
+	// Each zone's share of the traffic, instead of its traffic.
+	seriesZones := zones
+	if dqj.PercentOfTotal {
+		seriesZones = percentOfTotalZones(zones, req.metrics)
+		for _, zd := range seriesZones {
+			if dqj.Precision != nil {
+				zd.roundValues(*dqj.Precision)
+			}
+		}
 	}
 
 	// Create the response data frame.
-	frame := data.NewFrame("response")
+	var frame *data.Frame
+	if dqj.FrameFormat == FRAME_FORMAT_LONG {
+		frame = longFrame(seriesZones, req.metrics, dqj.MetricName, req.timeFieldName, dqj.Cumulative, dqj.IncludeRate, dqj.IncludeCoverage)
+	} else {
+		frame = wideFrame(seriesZones, req.metrics, dqj.MetricName, req.timeFieldName, dqj.Cumulative, dqj.IncludeRate, dqj.IncludeCoverage)
+	}
+	affixSeriesNames(frame, dss.SeriesNamePrefix, dss.SeriesNameSuffix)
+	if dqj.ValueType == VALUE_TYPE_INT {
+		integerValueFields(frame)
+	}
 
-	// If the user configured a metric name then use that. Else generate a metric name.
-	metricName := dqj.MetricName
-	if len(metricName) == 0 {
-		// Metric name not configured. Create the default name.
-		metricName = dqj.DomainName + " hits"
+	// The frame is a time series. grafana-plugin-sdk-go v0.86.0 predates data.FrameType,
+	// so the best available hint is the preferred visualization.
+	frame.Meta = &data.FrameMeta{
+		PreferredVisualization: data.VisTypeGraph,
+		Custom:                 req.customMeta,
+		ExecutedQueryString:    executedQueryString,
+	}
+	if len(req.notices) > 0 {
+		frame.AppendNotices(req.notices...)
 	}
+	frames := []*data.Frame{frame}
 
-	// Add data to the response data frame.
-	frame.Fields = append(frame.Fields, data.NewField("time", nil, sampletime))     // add the time dimension to dataframe
-	frame.Fields = append(frame.Fields, data.NewField(metricName, nil, hitspersec)) // add values to dataframe
+	// "How many hits in this range", alongside the time series.
+	if dqj.IncludeTotal {
+		frame := summaryOnlyFrame(zones, req.metrics, dqj.MetricName, dqj.PercentOfTotal, req.totalNA)
+		frame.Name = "total"
+		affixSeriesNames(frame, dss.SeriesNamePrefix, dss.SeriesNameSuffix)
+		if dqj.ValueType == VALUE_TYPE_INT {
+			integerValueFields(frame)
+		}
+		frames = append(frames, frame)
+	}
 
-	// Add the dataframe to the response
-	response.Frames = append(response.Frames, frame)
+	if dqj.IncludeSummary {
+		for _, zd := range zones {
+			frameName := "summary"
+			if len(zones) > 1 {
+				frameName = zd.zone + " summary"
+			}
+			frames = append(frames, summaryStatisticsFrame(frameName, zd.summaryStatistics, logger))
+		}
+	}
 
-	return response
+	if dqj.IncludeMetadata {
+		for _, zd := range zones {
+			frameName := "metadata"
+			if len(zones) > 1 {
+				frameName = zd.seriesZone() + " metadata"
+			}
+			frames = append(frames, metadataFrame(frameName, zd.metadata))
+		}
+	}
+	return frames
 }
 
 // The 'Save & Test' button on the datasource configuration page allows users to verify that the datasource is working as expected.
 func (td *AkamaiEdgeDnsDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	// log.DefaultLogger.Info("CheckHealth", "clientSecret", ds.ClientSecret)
-	// log.DefaultLogger.Info("CheckHealth", "host", ds.Host)
-	// log.DefaultLogger.Info("CheckHealth", "accessToken", ds.AccessToken)
-	// log.DefaultLogger.Info("CheckHealth", "clientToken", ds.ClientToken)
-
-	var ds dataSourceSettingsJson
-	err := json.Unmarshal(req.PluginContext.DataSourceInstanceSettings.JSONData, &ds)
+	// Invalid settings, or credentials that can't be read, fail the instance's creation.
+	instance, err := td.im.Get(req.PluginContext)
 	if err != nil {
 		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusUnknown,
-			Message: "Internal error. Failed to unmarshal healthcheck JSON",
-		}, err
+			Status:  backend.HealthStatusError,
+			Message: err.Error(),
+		}, nil
+	}
+	settings := instance.(*instanceSettings)
+	ds := settings.dss
+
+	// Without access to the OPEN API (e.g. in air-gapped CI), only check that the configuration is well-formed.
+	if ds.OfflineHealthCheck {
+		if err := validateCredentials(ds); err != nil {
+			return &backend.CheckHealthResult{
+				Status:  backend.HealthStatusError,
+				Message: err.Error(),
+			}, nil
+		}
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusOk,
+			Message: "Config valid (not verified against API)",
+		}, nil
+	}
+
+	// A recent success needn't be repeated.
+	if message, ok := settings.cachedHealthCheck(timeNow()); ok {
+		log.DefaultLogger.Info("CheckHealth", "cached", message)
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusOk,
+			Message: message,
+		}, nil
 	}
 
+	apiSettings := settings.openApi
+
 	// Verify that the OPEN API responds.
-	message, status := gtmOpenApiHealthCheck(ds.ClientSecret, ds.Host, ds.AccessToken, ds.ClientToken)
+	message, status := gtmOpenApiHostsHealthCheck(ctx, apiSettings, settings.maxClockSkew)
+
+	// Truncated responses fail to decode, so check that a moderately large one fits.
+	if status == backend.HealthStatusOk && len(ds.ResponseSizeProbeZone) > 0 {
+		if warning := gtmOpenApiResponseSizeProbe(ctx, apiSettings, ds.ResponseSizeProbeZone); len(warning) > 0 {
+			message += ". " + warning
+		}
+	}
+	if status == backend.HealthStatusOk {
+		settings.cacheHealthCheck(message, timeNow())
+	}
 
 	return &backend.CheckHealthResult{
 		Status:  status,
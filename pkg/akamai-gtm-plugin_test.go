@@ -0,0 +1,127 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+)
+
+func TestQueryDataUser(t *testing.T) {
+	var requests int32
+	server := newTestServer(t, cannedResponse{200, hitsBody}, &requests)
+	ds := &AkamaiEdgeDnsDatasource{
+		im: datasource.NewInstanceManager(func(setting backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+			instance, err := newDataSourceInstance(setting)
+			if err != nil {
+				return nil, err
+			}
+			// Trust the server's test certificate.
+			instance.(*instanceSettings).httpClient = server.Client()
+			instance.(*instanceSettings).openApi.httpClient = server.Client()
+			return instance, nil
+		}),
+	}
+	jsonData, _ := json.Marshal(map[string]string{
+		"host":         strings.TrimPrefix(server.URL, "https://"),
+		"clientSecret": "secret",
+		"accessToken":  "akab-access-token",
+		"clientToken":  "akab-client-token",
+	})
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		user *backend.User
+	}{
+		{name: "dashboard user", user: &backend.User{Login: "admin", Role: "Admin"}},
+		{name: "alerting, no user", user: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: backend.PluginContext{
+					User:                       tt.user,
+					DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{ID: 1, JSONData: jsonData},
+				},
+				Queries: []backend.DataQuery{{
+					RefID:     "A",
+					JSON:      json.RawMessage(`{"domainName": "example.akadns.net", "maxDataPoints": 100}`),
+					TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+				}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := rsp.Responses["A"]
+			if res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			if len(res.Frames) != 1 || res.Frames[0].Rows() != 2 {
+				t.Fatalf("frames = %v, want one with the response's 2 rows", res.Frames)
+			}
+		})
+	}
+}
+
+func TestValidateZoneName(t *testing.T) {
+	tests := []struct {
+		zone    string
+		wantErr bool
+	}{
+		{zone: "example.akadns.net"},
+		{zone: "Example.AKADNS.net"},
+		{zone: "a&b#c%d.akadns.net"}, // URL-encoded, not refused
+		{zone: "bücher.akadns.net"},
+		{zone: "", wantErr: true},
+		{zone: "example .akadns.net", wantErr: true},
+		{zone: "example.akadns.net\t", wantErr: true},
+		{zone: "example.akadns.net\nX-Injected: 1", wantErr: true},
+		{zone: "example\x00.akadns.net", wantErr: true},
+		{zone: "example\u00a0.akadns.net", wantErr: true}, // a no-break space
+	}
+	for _, tt := range tests {
+		err := validateZoneName(tt.zone)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateZoneName(%q) = %v, want error: %v", tt.zone, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLowercaseZoneNames(t *testing.T) {
+	tests := []struct {
+		zones []string
+		want  []string
+	}{
+		{zones: []string{"Example.AKADNS.net"}, want: []string{"example.akadns.net"}},
+		{zones: []string{"b.akadns.net", "A.akadns.net"}, want: []string{"b.akadns.net", "a.akadns.net"}},
+		{zones: []string{"a.akadns.net", "A.AKADNS.NET", "b.akadns.net", "a.Akadns.net"}, want: []string{"a.akadns.net", "b.akadns.net"}},
+		{zones: nil, want: nil},
+	}
+	for _, tt := range tests {
+		got := lowercaseZoneNames(tt.zones)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("lowercaseZoneNames(%q) = %q, want %q", tt.zones, got, tt.want)
+		}
+	}
+}
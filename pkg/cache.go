@@ -0,0 +1,160 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+)
+
+// OPEN API responses are cached with their validators (ETag, Last-Modified).
+// An identical request then asks whether the response changed, and reuses the cached body if it didn't.
+// Entries have no TTL: each reuse is revalidated by the API.
+
+// The most responses kept. When full, the cache is emptied: frequently refreshed queries soon repopulate it.
+const RESPONSE_CACHE_MAX_ENTRIES = 1000
+
+// A response body and the validators it was sent with.
+type cachedResponse struct {
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// A nil cache is disabled: nothing is cached.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+
+	// How well the cache works, e.g. to tune its size.
+	bytes     int64  // the cached bodies' size
+	hits      uint64 // responses not modified since cached: the cached body was reused
+	misses    uint64 // responses not cached, or modified since
+	evictions uint64 // entries dropped to make room
+}
+
+// The cache's effectiveness, for the /cache-stats resource.
+type responseCacheStats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"` // approximate memory use: the cached bodies' size
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Shared    bool   `json:"shared"` // the shared cache's stats include the other datasources'
+	Disabled  bool   `json:"disabled"`
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]cachedResponse)}
+}
+
+// The cache shared by the datasource instances that enable it, so identically-configured datasources reuse each other's responses.
+var sharedResponseCache = newResponseCache()
+
+// Identifies the credentials without revealing them. Responses are only reused by the same credentials,
+// so a datasource can't read data its credentials aren't authorized for from the shared cache.
+func credentialFingerprint(host string, accessToken string, clientToken string, clientSecret string) string {
+	sum := sha256.Sum256([]byte(host + "\n" + accessToken + "\n" + clientToken + "\n" + clientSecret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Requests are identical if their credentials, method, URL and body are.
+func responseCacheKey(fingerprint string, method string, openurl string, body []byte) string {
+	return fingerprint + "\n" + method + " " + openurl + "\n" + string(body)
+}
+
+func (c *responseCache) get(key string) (cachedResponse, bool) {
+	if c == nil {
+		return cachedResponse{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+// Keep the response's body if it has validators. Else it can't be revalidated: forget any earlier response.
+func (c *responseCache) put(key string, header http.Header, body []byte) {
+	entry := cachedResponse{
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+		body:         body,
+	}
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		c.bytes -= int64(len(old.body))
+		delete(c.entries, key)
+	}
+	if len(entry.etag) == 0 && len(entry.lastModified) == 0 {
+		return
+	}
+	if len(c.entries) >= RESPONSE_CACHE_MAX_ENTRIES {
+		c.evictions += uint64(len(c.entries))
+		c.entries = make(map[string]cachedResponse)
+		c.bytes = 0
+	}
+	c.entries[key] = entry
+	c.bytes += int64(len(body))
+}
+
+// Count a response as a hit, if the cached body was reused, else as a miss.
+func (c *responseCache) record(hit bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+func (c *responseCache) stats() responseCacheStats {
+	if c == nil {
+		return responseCacheStats{Disabled: true}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return responseCacheStats{
+		Entries:   len(c.entries),
+		Bytes:     c.bytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Shared:    c == sharedResponseCache,
+	}
+}
+
+// The headers asking whether the cached response changed.
+func (entry cachedResponse) conditionalHeader() http.Header {
+	header := make(http.Header)
+	if len(entry.etag) > 0 {
+		header.Set("If-None-Match", entry.etag)
+	}
+	if len(entry.lastModified) > 0 {
+		header.Set("If-Modified-Since", entry.lastModified)
+	}
+	return header
+}
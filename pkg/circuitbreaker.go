@@ -0,0 +1,105 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Stops sending OPEN API requests for a while after repeated failures, e.g. while the API is down,
+// so the dashboard's panels fail fast instead of each waiting for a timeout.
+// After the cooldown, a single request probes the API: its success closes the circuit, its failure opens it again.
+type circuitBreaker struct {
+	threshold int           // consecutive failures that open the circuit
+	window    time.Duration // in which they must happen
+	cooldown  time.Duration // how long the circuit stays open
+
+	mu           sync.Mutex
+	failures     int       // consecutive failures
+	firstFailure time.Time // of the consecutive failures
+	openUntil    time.Time // zero: closed
+	probing      bool      // a request is probing the API after the cooldown
+}
+
+const (
+	DEFAULT_CIRCUIT_BREAKER_WINDOW   = 1 * time.Minute
+	DEFAULT_CIRCUIT_BREAKER_COOLDOWN = 30 * time.Second
+)
+
+var ErrCircuitOpen = errors.New("API unavailable (circuit open)")
+
+// nil (never open) if threshold is 0.
+func newCircuitBreaker(threshold uint, window time.Duration, cooldown time.Duration) *circuitBreaker {
+	if threshold == 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: int(threshold), window: window, cooldown: cooldown}
+}
+
+// ErrCircuitOpen if the request mustn't be sent: the circuit is open, or another request is probing the API.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if timeNow().Before(b.openUntil) || b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// Record the request's outcome.
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := timeNow()
+	wasProbing := b.probing
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if wasProbing || b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// The request was canceled: it tells nothing about the API. Another request may probe it.
+func (b *circuitBreaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"errors"
+
+	"github.com/akamai/AkamaiOPEN-edgegrid-golang/edgegrid"
+)
+
+// Where the EdgeGrid credentials come from
+const (
+	CREDENTIAL_SOURCE_JSON_DATA = "jsonData" // the datasource settings (default)
+	CREDENTIAL_SOURCE_ENV       = "env"      // AKAMAI_[SECTION_]HOST, etc. environment variables of the Grafana server
+	CREDENTIAL_SOURCE_EDGERC    = "edgerc"   // a section of an .edgerc file on the Grafana server
+)
+
+// A source of EdgeGrid credentials, e.g. a secret store managed outside Grafana.
+type credentialProvider interface {
+	credentials() (edgegrid.Config, error)
+}
+
+// The credentials entered in the datasource settings.
+type jsonDataCredentials struct {
+	dss dataSourceSettingsJson
+}
+
+func (p jsonDataCredentials) credentials() (edgegrid.Config, error) {
+	return *NewEdgegridConfig(p.dss.ClientSecret, p.dss.Host, p.dss.AccessToken, p.dss.ClientToken), nil
+}
+
+// The credentials in environment variables, e.g. injected from Vault.
+type envCredentials struct {
+	section string
+}
+
+func (p envCredentials) credentials() (edgegrid.Config, error) {
+	return edgegrid.InitEnv(p.section)
+}
+
+// The credentials in an .edgerc file, e.g. rendered by a Vault agent.
+type edgercCredentials struct {
+	path    string
+	section string
+}
+
+func (p edgercCredentials) credentials() (edgegrid.Config, error) {
+	return edgegrid.InitEdgeRc(p.path, p.section)
+}
+
+// The datasource's credential provider.
+func newCredentialProvider(dss dataSourceSettingsJson) (credentialProvider, error) {
+	switch dss.CredentialSource {
+	case CREDENTIAL_SOURCE_JSON_DATA, "":
+		return jsonDataCredentials{dss: dss}, nil
+	case CREDENTIAL_SOURCE_ENV:
+		return envCredentials{section: dss.CredentialSection}, nil
+	case CREDENTIAL_SOURCE_EDGERC:
+		if len(dss.EdgercPath) == 0 {
+			return nil, errors.New("Enter the .edgerc file path")
+		}
+		return edgercCredentials{path: dss.EdgercPath, section: dss.CredentialSection}, nil
+	default:
+		return nil, errors.New("Invalid credential source: " + dss.CredentialSource)
+	}
+}
+
+// Fill in the settings' credentials from their provider, so the rest of the plugin needn't know where they came from.
+func resolveCredentials(dss *dataSourceSettingsJson) error {
+	provider, err := newCredentialProvider(*dss)
+	if err != nil {
+		return err
+	}
+	config, err := provider.credentials()
+	if err != nil {
+		return errors.New("Failed to get the credentials: " + err.Error())
+	}
+	dss.ClientSecret = config.ClientSecret
+	dss.Host = config.Host
+	dss.AccessToken = config.AccessToken
+	dss.ClientToken = config.ClientToken
+	return nil
+}
@@ -0,0 +1,885 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Frame formats
+const (
+	FRAME_FORMAT_WIDE = "wide" // a time field, then a value field per zone and metric, labeled with the zone
+	FRAME_FORMAT_LONG = "long" // a time field, a zone field, then a value field per metric
+)
+
+// The data for one zone: the sample times and, for each metric, a value per sample time.
+type zoneData struct {
+	zone              string
+	alias             string // the zone's display name, e.g. "Production EU". "": the default naming
+	compareOffset     string // for an earlier period's data, re-based to the query's time range: the offset, e.g. "7d"
+	sampletime        []time.Time
+	metricValues      [][]*float64 // indexed like the query's metrics
+	summaryStatistics map[string]json.RawMessage
+	metadata          Metadata
+	percentOfTotal    bool      // the values of counts are the zone's percentage of all the zones' total
+	interval          Interval  // the interval of the data: the API's, which may be coarser than requested
+	coverage          []float64 // per sample time, the percentage of the metrics the API reported a value for, not "N/A"
+	reported          [][]bool  // indexed like metricValues: did the API report the value, rather than "N/A"
+}
+
+// Put the data items in the OPEN API response into slices, ready for the dataframe.
+// With zeroAsNull, values the API reports as 0 are null, to tell "no traffic" from "no data".
+// The interval is the one the response's metadata reports, else the requested one.
+func newZoneData(zone string, rspDto *GtmDnsTrafficAllPropertiesRspDto, metrics []string, zeroAsNull bool, requested Interval, logger log.Logger) (*zoneData, error) {
+	numDataRows := len(rspDto.Data)
+	interval := Interval(rspDto.Metadata.Interval)
+	if !interval.Valid() {
+		interval = requested
+	}
+	zd := &zoneData{
+		zone:              zone,
+		interval:          interval,
+		sampletime:        make([]time.Time, numDataRows),
+		metricValues:      make([][]*float64, len(metrics)),
+		coverage:          make([]float64, numDataRows),
+		reported:          make([][]bool, len(metrics)),
+		summaryStatistics: rspDto.SummaryStatistics,
+		metadata:          rspDto.Metadata,
+	}
+	for m := range metrics {
+		zd.metricValues[m] = make([]*float64, numDataRows)
+		zd.reported[m] = make([]bool, numDataRows)
+	}
+
+	// The response contains data for each of the requested metrics.
+	for i, datum := range rspDto.Data {
+		startTime, err := datum.startTime()
+		if err != nil {
+			return nil, err
+		}
+		zd.sampletime[i] = startTime
+
+		// Look the metrics up by name: the order of the keys in the response doesn't matter.
+		reported := 0
+		for m, metric := range metrics {
+			zd.metricValues[m][i] = parseMetricValue(datum.Metrics[metric], zeroAsNull)
+			zd.reported[m][i] = isReportedValue(datum.Metrics[metric])
+			if zd.reported[m][i] {
+				reported++
+			}
+		}
+		if len(metrics) > 0 {
+			zd.coverage[i] = float64(reported) / float64(len(metrics)) * 100
+		}
+	}
+
+	// The rows are usually in time order, but not always. Out-of-order rows make a jagged graph.
+	zd.sortByTime(logger)
+	return zd, nil
+}
+
+// A metric's value. Some data will be "N/A": the count is then zero.
+// A reported 0 is null with zeroAsNull. "N/A" is not a reported 0.
+func parseMetricValue(text string, zeroAsNull bool) *float64 {
+	value, err := strconv.ParseFloat(text, 64)
+	if err == nil && value == 0 && zeroAsNull {
+		return nil
+	}
+	return &value
+}
+
+// Did the API report a value, rather than "N/A" or nothing? A reported 0 is a value, even with zeroAsNull.
+func isReportedValue(text string) bool {
+	_, err := strconv.ParseFloat(text, 64)
+	return err == nil
+}
+
+// Put the rows in time order. Rows with the same time stay in the response's order.
+func (zd *zoneData) sortByTime(logger log.Logger) {
+	if sort.SliceIsSorted(zd.sampletime, func(i, j int) bool { return zd.sampletime[i].Before(zd.sampletime[j]) }) {
+		return
+	}
+	logger.Debug("sortByTime", "zone", zd.zone, "order", "unsorted")
+
+	order := make([]int, len(zd.sampletime))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return zd.sampletime[order[i]].Before(zd.sampletime[order[j]]) })
+
+	sampletime := make([]time.Time, len(order))
+	coverage := make([]float64, len(order))
+	for i, row := range order {
+		sampletime[i] = zd.sampletime[row]
+		coverage[i] = zd.coverage[row]
+	}
+	zd.sampletime = sampletime
+	zd.coverage = coverage
+	for m, values := range zd.metricValues {
+		sorted := make([]*float64, len(order))
+		for i, row := range order {
+			sorted[i] = values[row]
+		}
+		zd.metricValues[m] = sorted
+	}
+	for m, reported := range zd.reported {
+		sorted := make([]bool, len(order))
+		for i, row := range order {
+			sorted[i] = reported[row]
+		}
+		zd.reported[m] = sorted
+	}
+}
+
+// How rows with the same time are merged
+const (
+	DUPLICATE_TIMES_LAST = "last" // keep the last row's values
+	DUPLICATE_TIMES_SUM  = "sum"  // add the rows' values
+	DUPLICATE_TIMES_DROP = "drop" // keep the first row's values, dropping the others
+)
+
+// The OPEN API occasionally returns two rows for a time, at interval boundaries. Merge them into one row,
+// so each time is only graphed once.
+func (zd *zoneData) mergeDuplicateTimes(duplicateTimes string, logger log.Logger) {
+	rows := make(map[int64]int, len(zd.sampletime))
+	var sampletime []time.Time
+	var coverage []float64
+	metricValues := make([][]*float64, len(zd.metricValues))
+	reported := make([][]bool, len(zd.reported))
+	for i, t := range zd.sampletime {
+		row, seen := rows[t.UnixNano()]
+		if !seen {
+			rows[t.UnixNano()] = len(sampletime)
+			sampletime = append(sampletime, t)
+			coverage = append(coverage, zd.coverage[i])
+			for m := range zd.metricValues {
+				metricValues[m] = append(metricValues[m], zd.metricValues[m][i])
+				reported[m] = append(reported[m], zd.reported[m][i])
+			}
+			continue
+		}
+
+		// The last row's coverage replaces the merged row's. Summed, a metric has a value if either row's did.
+		switch duplicateTimes {
+		case DUPLICATE_TIMES_LAST:
+			coverage[row] = zd.coverage[i]
+		case DUPLICATE_TIMES_SUM:
+			coverage[row] = math.Max(coverage[row], zd.coverage[i])
+		}
+		for m := range zd.metricValues {
+			value := zd.metricValues[m][i]
+			switch duplicateTimes {
+			case DUPLICATE_TIMES_LAST:
+				metricValues[m][row] = value
+				reported[m][row] = zd.reported[m][i]
+			case DUPLICATE_TIMES_SUM:
+				reported[m][row] = reported[m][row] || zd.reported[m][i]
+				if value != nil && metricValues[m][row] != nil {
+					sum := *metricValues[m][row] + *value
+					metricValues[m][row] = &sum
+				} else if value != nil {
+					metricValues[m][row] = value
+				}
+			}
+		}
+	}
+
+	merged := len(zd.sampletime) - len(sampletime)
+	if merged > 0 {
+		logger.Debug("mergeDuplicateTimes", "zone", zd.zone, "duplicateTimes", duplicateTimes, "merged", merged)
+		zd.sampletime = sampletime
+		zd.coverage = coverage
+		zd.metricValues = metricValues
+		zd.reported = reported
+	}
+}
+
+// If the most recent interval is still being collected, null or drop it as configured.
+func (zd *zoneData) handlePartialInterval(interval Interval, partialInterval string, now time.Time, logger log.Logger) {
+	numDataRows := len(zd.sampletime)
+	if numDataRows == 0 || partialInterval == PARTIAL_INTERVAL_KEEP {
+		return
+	}
+
+	last := numDataRows - 1
+	if !intervalIsIncomplete(zd.sampletime[last], interval, now) {
+		return
+	}
+	logger.Debug("handlePartialInterval", "zone", zd.zone, "partialInterval", partialInterval, "time", zd.sampletime[last])
+
+	if partialInterval == PARTIAL_INTERVAL_DROP {
+		zd.sampletime = zd.sampletime[:last]
+		zd.coverage = zd.coverage[:last]
+		for m := range zd.metricValues {
+			zd.metricValues[m] = zd.metricValues[m][:last]
+			zd.reported[m] = zd.reported[m][:last]
+		}
+	} else {
+		zd.coverage[last] = 0
+		for m := range zd.metricValues {
+			zd.metricValues[m][last] = nil
+			zd.reported[m][last] = false
+		}
+	}
+}
+
+// The most decimal places values can be rounded to. float64 has about 15 significant digits.
+const MAX_PRECISION = 15
+
+// Round the values to 'precision' decimal places, e.g. for clean tooltips and CSV exports.
+func (zd *zoneData) roundValues(precision int) {
+	scale := math.Pow10(precision)
+	for _, values := range zd.metricValues {
+		for i, value := range values {
+			if value != nil {
+				rounded := math.Round(*value*scale) / scale
+				values[i] = &rounded
+			}
+		}
+	}
+}
+
+// If the user configured a metric name then use that. Else the name is the metric.
+// When several metrics are graphed, each name includes its metric.
+// The zone is not part of the name: it is the field's "zone" label. The metric is also its "metric" label.
+func seriesName(userMetricName string, metric string, numMetrics int) string {
+	if len(userMetricName) == 0 {
+		// Metric name not configured. Use the metric.
+		return metric
+	}
+	if numMetrics > 1 {
+		return userMetricName + " " + metric
+	}
+	return userMetricName
+}
+
+// The name of a zone's total of a metric, e.g. "Total hits".
+func summaryName(userMetricName string, metric string, numMetrics int) string {
+	if len(userMetricName) > 0 {
+		return seriesName(userMetricName, metric, numMetrics)
+	}
+	return "Total " + metric
+}
+
+// Without a metric name, the fields of several zones are displayed by zone, e.g. "example.akadns.net".
+// Zones compared with an earlier period count once.
+func nameByZone(zones []*zoneData, userMetricName string) bool {
+	if len(userMetricName) > 0 {
+		return false
+	}
+	for _, zd := range zones {
+		if zd.zone != zones[0].zone {
+			return true
+		}
+	}
+	return false
+}
+
+func percentFieldConfig() *data.FieldConfig {
+	return (&data.FieldConfig{Unit: "percent"}).SetMin(0).SetMax(100)
+}
+
+// The display config of the zone's value fields: a share of the total is a percentage.
+func (zd *zoneData) valueFieldConfig() *data.FieldConfig {
+	if zd.percentOfTotal {
+		return percentFieldConfig()
+	}
+	return nil
+}
+
+// Each zone's share of all the zones' total per time, as a percentage, e.g. for traffic distribution.
+// The zones of an earlier period are shares of that period's total. Where the total is zero, the shares are null.
+func percentOfTotalZones(zones []*zoneData, metrics []string) []*zoneData {
+	// The total of each period's metrics at each time.
+	totals := make(map[string][]map[int64]float64)
+	for _, zd := range zones {
+		periodTotals, ok := totals[zd.compareOffset]
+		if !ok {
+			periodTotals = make([]map[int64]float64, len(metrics))
+			for m := range metrics {
+				periodTotals[m] = make(map[int64]float64)
+			}
+			totals[zd.compareOffset] = periodTotals
+		}
+		for m := range metrics {
+			for i, value := range zd.metricValues[m] {
+				if value != nil {
+					periodTotals[m][zd.sampletime[i].UnixNano()] += *value
+				}
+			}
+		}
+	}
+
+	shares := make([]*zoneData, len(zones))
+	for z, zd := range zones {
+		share := *zd
+		share.percentOfTotal = true
+		share.metricValues = make([][]*float64, len(metrics))
+		for m := range metrics {
+			share.metricValues[m] = make([]*float64, len(zd.metricValues[m]))
+			for i, value := range zd.metricValues[m] {
+				total := totals[zd.compareOffset][m][zd.sampletime[i].UnixNano()]
+				if value != nil && total != 0 {
+					percent := *value / total * 100
+					share.metricValues[m][i] = &percent
+				}
+			}
+		}
+		shares[z] = &share
+	}
+	return shares
+}
+
+// The display config of a zone's field of a metric. An aliased zone's field is displayed as e.g. "Production EU hits".
+// Named by zone, it is displayed as its alias or zone, followed by the field name unless that is "".
+func (zd *zoneData) seriesFieldConfig(fieldName string, byZone bool) *data.FieldConfig {
+	config := zd.valueFieldConfig()
+	zoneName := zd.alias
+	if len(zoneName) == 0 && byZone {
+		zoneName = zd.zone
+	}
+	if len(zoneName) == 0 {
+		return config
+	}
+	if config == nil {
+		config = &data.FieldConfig{}
+	}
+	config.DisplayNameFromDS = strings.TrimSpace(zoneName + " " + fieldName)
+	if len(zd.compareOffset) > 0 {
+		config.DisplayNameFromDS += " -" + zd.compareOffset
+	}
+	return config
+}
+
+// The zone's alias, matching the zone name case-insensitively. "" if it has none.
+func zoneAlias(zone string, zoneAliases map[string]string) string {
+	if alias, ok := zoneAliases[zone]; ok {
+		return alias
+	}
+	for aliasedZone, alias := range zoneAliases {
+		if strings.EqualFold(zone, aliasedZone) {
+			return alias
+		}
+	}
+	return ""
+}
+
+// The labels identifying a zone's series of a metric, to group or filter by either.
+// An earlier period's series also has an "offset" label.
+func (zd *zoneData) seriesLabels(metric string) data.Labels {
+	labels := data.Labels{"zone": zd.zone, "metric": metric}
+	if len(zd.compareOffset) > 0 {
+		labels["offset"] = zd.compareOffset
+	}
+	return labels
+}
+
+// The zone, with the offset of an earlier period's data, e.g. "example.akadns.net -7d".
+func (zd *zoneData) seriesZone() string {
+	if len(zd.compareOffset) > 0 {
+		return zd.zone + " -" + zd.compareOffset
+	}
+	return zd.zone
+}
+
+// Move the sample times later by 'offset', e.g. so last week's data lines up with this week's.
+func (zd *zoneData) shiftTimes(offset time.Duration) {
+	for i, t := range zd.sampletime {
+		zd.sampletime[i] = t.Add(offset)
+	}
+}
+
+// The sample times of all the zones, in order, without duplicates.
+func allSampleTimes(zones []*zoneData) []time.Time {
+	seen := make(map[int64]bool)
+	var sampletime []time.Time
+	for _, zd := range zones {
+		for _, t := range zd.sampletime {
+			if !seen[t.UnixNano()] {
+				seen[t.UnixNano()] = true
+				sampletime = append(sampletime, t)
+			}
+		}
+	}
+	sort.Slice(sampletime, func(i, j int) bool { return sampletime[i].Before(sampletime[j]) })
+	return sampletime
+}
+
+// Index of each sample time in the zone's slices.
+func (zd *zoneData) rowsByTime() map[int64]int {
+	rows := make(map[int64]int, len(zd.sampletime))
+	for i, t := range zd.sampletime {
+		rows[t.UnixNano()] = i
+	}
+	return rows
+}
+
+// Do the zone's values have a running total? Counts do, but summing shares of the total is meaningless.
+func (zd *zoneData) hasRunningTotal() bool {
+	return !zd.percentOfTotal
+}
+
+// The running total of the values, e.g. for a cumulative curve. Nulls add nothing: the total carries over them.
+func runningTotal(values []*float64) []*float64 {
+	totals := make([]*float64, len(values))
+	var total float64
+	for i, value := range values {
+		if value != nil {
+			total += *value
+		}
+		runningTotal := total
+		totals[i] = &runningTotal
+	}
+	return totals
+}
+
+// The per-second rate of a value over the interval, e.g. hits per second. Null stays null.
+func perSecondRate(value *float64, interval time.Duration) *float64 {
+	if value == nil {
+		return nil
+	}
+	rate := *value / interval.Seconds()
+	return &rate
+}
+
+// The per-second rates of the zone's values over its interval.
+func (zd *zoneData) perSecondRates(values []*float64) []*float64 {
+	rates := make([]*float64, len(values))
+	for i, value := range values {
+		rates[i] = perSecondRate(value, zd.interval.Duration())
+	}
+	return rates
+}
+
+// The display config with the unit of a count alongside its rate: a plain number.
+func withCountUnit(config *data.FieldConfig) *data.FieldConfig {
+	if config == nil {
+		config = &data.FieldConfig{}
+	}
+	config.Unit = "short"
+	return config
+}
+
+// Grafana's unit of requests per second.
+const RATE_UNIT = "reqps"
+
+// The display config with the unit of a count's per-second rate: requests per second.
+func withRateUnit(config *data.FieldConfig) *data.FieldConfig {
+	if config == nil {
+		config = &data.FieldConfig{}
+	}
+	config.Unit = RATE_UNIT
+	return config
+}
+
+// The name of the field of each zone's coverage: the percentage of the metrics with a reported value at each time.
+const COVERAGE_FIELD = "coverage"
+
+// The display config of the zone's coverage field: a percentage, named like the zone's series, e.g. "Production EU coverage".
+func (zd *zoneData) coverageFieldConfig(byZone bool) *data.FieldConfig {
+	config := percentFieldConfig()
+	if series := zd.seriesFieldConfig(COVERAGE_FIELD, byZone); series != nil {
+		config.DisplayNameFromDS = series.DisplayNameFromDS
+	}
+	return config
+}
+
+// A time field, then a value field per zone and metric.
+// Zones may not have data at every time: their values are null there.
+// With cumulative, each count's field is followed by a field of its running total over the time range, e.g. "hits cumulative".
+// With rate, each count's field is followed by a field of its per-second rate over the zone's interval, e.g. "hits per second".
+// With coverage, each zone's fields are followed by its coverage field: 0 where it has no data.
+func wideFrame(zones []*zoneData, metrics []string, userMetricName string, timeFieldName string, cumulative bool, rate bool, coverage bool) *data.Frame {
+	frame := data.NewFrame("response")
+	sampletime := allSampleTimes(zones)
+	frame.Fields = append(frame.Fields, data.NewField(timeFieldName, nil, sampletime)) // add the time dimension to dataframe
+
+	byZone := nameByZone(zones, userMetricName)
+	for _, zd := range zones {
+		rows := zd.rowsByTime()
+		for m, metric := range metrics {
+			values := make([]*float64, len(sampletime))
+			for i, t := range sampletime {
+				if row, ok := rows[t.UnixNano()]; ok {
+					values[i] = zd.metricValues[m][row]
+				}
+			}
+			fieldName := seriesName(userMetricName, metric, len(metrics))
+			displayFieldName := fieldName
+			if byZone && len(metrics) == 1 {
+				displayFieldName = "" // just the zone
+			}
+			config := zd.seriesFieldConfig(displayFieldName, byZone)
+			withRate := rate && zd.hasRunningTotal()
+			if withRate {
+				config = withCountUnit(config)
+			}
+			field := data.NewField(fieldName, zd.seriesLabels(metric), values).SetConfig(config)
+			frame.Fields = append(frame.Fields, field) // add values to dataframe
+
+			if withRate {
+				rateDisplayName := strings.TrimSpace(displayFieldName + " per second")
+				field := data.NewField(fieldName+" per second", zd.seriesLabels(metric), zd.perSecondRates(values)).
+					SetConfig(withRateUnit(zd.seriesFieldConfig(rateDisplayName, byZone)))
+				frame.Fields = append(frame.Fields, field)
+			}
+
+			if cumulative && zd.hasRunningTotal() {
+				cumulativeDisplayName := strings.TrimSpace(displayFieldName + " cumulative")
+				field := data.NewField(fieldName+" cumulative", zd.seriesLabels(metric), runningTotal(values)).
+					SetConfig(zd.seriesFieldConfig(cumulativeDisplayName, byZone))
+				frame.Fields = append(frame.Fields, field)
+			}
+		}
+
+		if coverage {
+			values := make([]float64, len(sampletime))
+			for i, t := range sampletime {
+				if row, ok := rows[t.UnixNano()]; ok {
+					values[i] = zd.coverage[row]
+				}
+			}
+			field := data.NewField(COVERAGE_FIELD, zd.seriesLabels(COVERAGE_FIELD), values).SetConfig(zd.coverageFieldConfig(byZone))
+			frame.Fields = append(frame.Fields, field)
+		}
+	}
+	return frame
+}
+
+// A time field, a zone field, then a value field per metric: a row per time and zone, in time order.
+// With cumulative, each count's field is followed by a field of each zone's running total, e.g. "hits cumulative".
+// With rate, each count's field is followed by a field of its per-second rate over the zone's interval, e.g. "hits per second".
+// With coverage, the last field is each row's coverage.
+func longFrame(zones []*zoneData, metrics []string, userMetricName string, timeFieldName string, cumulative bool, rate bool, coverage bool) *data.Frame {
+	var sampletime []time.Time
+	var zoneNames []string
+	var rowIntervals []time.Duration // each row's zone's interval, for rates
+	var rowCoverage []float64
+	metricValues := make([][]*float64, len(metrics))
+
+	zoneRows := make([]map[int64]int, len(zones))
+	for z, zd := range zones {
+		zoneRows[z] = zd.rowsByTime()
+	}
+	for _, t := range allSampleTimes(zones) {
+		for z, zd := range zones {
+			row, ok := zoneRows[z][t.UnixNano()]
+			if !ok {
+				continue
+			}
+			sampletime = append(sampletime, t)
+			zoneNames = append(zoneNames, zd.seriesZone())
+			rowIntervals = append(rowIntervals, zd.interval.Duration())
+			rowCoverage = append(rowCoverage, zd.coverage[row])
+			for m := range metrics {
+				metricValues[m] = append(metricValues[m], zd.metricValues[m][row])
+			}
+		}
+	}
+
+	frame := data.NewFrame("response")
+	frame.Fields = append(frame.Fields, data.NewField(timeFieldName, nil, sampletime))
+	frame.Fields = append(frame.Fields, data.NewField("zone", nil, zoneNames))
+	for m, metric := range metrics {
+		fieldName := seriesName(userMetricName, metric, len(metrics))
+		var config *data.FieldConfig
+		if len(zones) > 0 {
+			config = zones[0].valueFieldConfig()
+		}
+		withRate := rate && len(zones) > 0 && zones[0].hasRunningTotal()
+		if withRate {
+			config = withCountUnit(config)
+		}
+		field := data.NewField(fieldName, data.Labels{"metric": metric}, metricValues[m]).SetConfig(config)
+		frame.Fields = append(frame.Fields, field)
+
+		if withRate {
+			rates := make([]*float64, len(metricValues[m]))
+			for i, value := range metricValues[m] {
+				rates[i] = perSecondRate(value, rowIntervals[i])
+			}
+			field := data.NewField(fieldName+" per second", data.Labels{"metric": metric}, rates).SetConfig(withRateUnit(nil))
+			frame.Fields = append(frame.Fields, field)
+		}
+
+		if cumulative && len(zones) > 0 && zones[0].hasRunningTotal() {
+			// Each zone's running total, in its rows.
+			totals := make(map[string]float64)
+			cumulativeValues := make([]*float64, len(metricValues[m]))
+			for i, value := range metricValues[m] {
+				if value != nil {
+					totals[zoneNames[i]] += *value
+				}
+				total := totals[zoneNames[i]]
+				cumulativeValues[i] = &total
+			}
+			field := data.NewField(fieldName+" cumulative", data.Labels{"metric": metric}, cumulativeValues).SetConfig(config)
+			frame.Fields = append(frame.Fields, field)
+		}
+	}
+	if coverage {
+		frame.Fields = append(frame.Fields, data.NewField(COVERAGE_FIELD, nil, rowCoverage).SetConfig(percentFieldConfig()))
+	}
+	return frame
+}
+
+// A single row with each zone's and metric's total, e.g. for a stat panel.
+// With percentOfTotal, a total is the zone's percentage of all the zones' total.
+// totalNA is how "N/A" counts add to the total, TOTAL_NA_ZERO or TOTAL_NA_SKIP.
+func summaryOnlyFrame(zones []*zoneData, metrics []string, userMetricName string, percentOfTotal bool, totalNA string) *data.Frame {
+	if percentOfTotal {
+		zones = percentOfTotalZones(totalZones(zones, metrics, totalNA), metrics)
+	}
+
+	frame := data.NewFrame("response")
+	byZone := nameByZone(zones, userMetricName)
+	for _, zd := range zones {
+		for m, metric := range metrics {
+			fieldName := summaryName(userMetricName, metric, len(metrics))
+			if percentOfTotal && len(userMetricName) == 0 {
+				fieldName = "Share of " + metric
+			}
+			field := data.NewField(fieldName, zd.seriesLabels(metric), []float64{sumValues(zd.metricValues[m])})
+			if totalNA == TOTAL_NA_SKIP {
+				field = data.NewField(fieldName, zd.seriesLabels(metric), []*float64{zd.reportedSum(m)})
+			}
+			if percentOfTotal {
+				field = data.NewField(fieldName, zd.seriesLabels(metric), []*float64{averageValues(zd.metricValues[m])})
+			}
+			frame.Fields = append(frame.Fields, field.SetConfig(zd.seriesFieldConfig(fieldName, byZone)))
+		}
+	}
+	return frame
+}
+
+// How "N/A" counts add to a total
+const (
+	TOTAL_NA_ZERO = "zero" // as 0: a total of only "N/A" counts is 0
+	TOTAL_NA_SKIP = "skip" // not at all: a total of only "N/A" counts is null
+)
+
+// Each zone's total over the time range, as a single row: the sum of its counts.
+func totalZones(zones []*zoneData, metrics []string, totalNA string) []*zoneData {
+	totals := make([]*zoneData, len(zones))
+	for z, zd := range zones {
+		total := *zd
+		total.sampletime = []time.Time{{}}
+		total.metricValues = make([][]*float64, len(metrics))
+		total.reported = make([][]bool, len(metrics))
+		for m := range metrics {
+			sum := sumValues(zd.metricValues[m])
+			total.metricValues[m] = []*float64{&sum}
+			if totalNA == TOTAL_NA_SKIP {
+				total.metricValues[m] = []*float64{zd.reportedSum(m)}
+			}
+			total.reported[m] = []bool{total.metricValues[m][0] != nil}
+		}
+		totals[z] = &total
+	}
+	return totals
+}
+
+// Add the prefix and suffix to the value fields' names and display names, e.g. "Prod: " to tell datasources apart.
+func affixSeriesNames(frame *data.Frame, prefix string, suffix string) {
+	if len(prefix) == 0 && len(suffix) == 0 {
+		return
+	}
+	for _, field := range frame.Fields {
+		if !field.Type().Numeric() {
+			continue
+		}
+		field.Name = prefix + field.Name + suffix
+		if field.Config != nil && len(field.Config.DisplayNameFromDS) > 0 {
+			field.Config.DisplayNameFromDS = prefix + field.Config.DisplayNameFromDS + suffix
+		}
+	}
+}
+
+// Value types of the value fields.
+const (
+	VALUE_TYPE_FLOAT = "float" // float64, as the API's values may be decimals
+	VALUE_TYPE_INT   = "int"   // int64, rounded, e.g. for panels expecting whole counts
+)
+
+// Fields in these units stay float64 with VALUE_TYPE_INT: rounding would lose them, e.g. 0.3 requests per second to 0.
+var fractionalUnits = map[string]bool{
+	"percent": true,
+	RATE_UNIT: true,
+}
+
+// Make the float64 value fields int64, rounding their values. Shares of the total and rates stay float64.
+func integerValueFields(frame *data.Frame) {
+	for i, field := range frame.Fields {
+		if field.Type() != data.FieldTypeFloat64 && field.Type() != data.FieldTypeNullableFloat64 {
+			continue
+		}
+		if field.Config != nil && fractionalUnits[field.Config.Unit] {
+			continue
+		}
+		values := make([]*int64, field.Len())
+		for row := range values {
+			if value, ok := field.ConcreteAt(row); ok {
+				rounded := int64(math.Round(value.(float64)))
+				values[row] = &rounded
+			}
+		}
+		frame.Fields[i] = data.NewField(field.Name, field.Labels, values).SetConfig(field.Config)
+	}
+}
+
+// The sum of the values, skipping nulls.
+func sumValues(values []*float64) float64 {
+	var sum float64
+	for _, value := range values {
+		if value != nil {
+			sum += *value
+		}
+	}
+	return sum
+}
+
+// The sum of the metric's reported values, skipping "N/A" and nulls. Null if none was reported.
+func (zd *zoneData) reportedSum(m int) *float64 {
+	var sum float64
+	reported := false
+	for i, value := range zd.metricValues[m] {
+		if value != nil && zd.reported[m][i] {
+			sum += *value
+			reported = true
+		}
+	}
+	if !reported {
+		return nil
+	}
+	return &sum
+}
+
+// The average of the values, skipping nulls. Null if all are null.
+func averageValues(values []*float64) *float64 {
+	var sum float64
+	var count int
+	for _, value := range values {
+		if value != nil {
+			sum += *value
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	average := sum / float64(count)
+	return &average
+}
+
+// A one-row frame with a field per summary statistic, named as in the API response.
+// Each field can drive a stat panel. Non-numeric statistics are null.
+func summaryStatisticsFrame(frameName string, summaryStatistics map[string]json.RawMessage, logger log.Logger) *data.Frame {
+	frame := data.NewFrame(frameName)
+
+	// The response has no summary statistics: return an empty frame.
+	if len(summaryStatistics) == 0 {
+		logger.Debug("summaryStatisticsFrame", "summaryStatistics", "absent")
+		return frame
+	}
+
+	// Map iteration order is random. Keep the fields in a stable order.
+	statNames := make([]string, 0, len(summaryStatistics))
+	for statName := range summaryStatistics {
+		statNames = append(statNames, statName)
+	}
+	sort.Strings(statNames)
+
+	for _, statName := range statNames {
+		var value *float64
+		if number, ok := summaryStatisticValue(summaryStatistics[statName]); ok {
+			value = &number
+		}
+		frame.Fields = append(frame.Fields, data.NewField(statName, nil, []*float64{value}))
+	}
+	return frame
+}
+
+// A one-row frame with a field per metadata item of the response, as returned. The object IDs are comma-separated.
+func metadataFrame(frameName string, metadata Metadata) *data.Frame {
+	return data.NewFrame(frameName,
+		data.NewField("name", nil, []string{metadata.Name}),
+		data.NewField("version", nil, []string{metadata.Version}),
+		data.NewField("objectType", nil, []string{metadata.ObjectType}),
+		data.NewField("objectIds", nil, []string{strings.Join(metadata.ObjectIds, ",")}),
+		data.NewField("interval", nil, []string{metadata.Interval}),
+		data.NewField("start", nil, []string{metadata.Start}),
+		data.NewField("end", nil, []string{metadata.End}),
+		data.NewField("availableDataEnds", nil, []string{metadata.AvailableDataEnds}),
+		data.NewField("rowCount", nil, []int64{int64(metadata.RowCount)}),
+		data.NewField("outputType", nil, []string{metadata.OutputType}),
+	)
+}
+
+// A time field, then a field per column of the response, in name order.
+// Columns whose values are all numbers (or "N/A") are numeric. Others, e.g. groupings, are strings.
+func columnsFrame(rspDto *GtmDnsTrafficAllPropertiesRspDto, timeFieldName string) (*data.Frame, error) {
+	columnSet := make(map[string]bool)
+	sampletime := make([]time.Time, len(rspDto.Data))
+	for i, datum := range rspDto.Data {
+		startTime, err := datum.startTime()
+		if err != nil {
+			return nil, err
+		}
+		sampletime[i] = startTime
+		for column := range datum.Metrics {
+			columnSet[column] = true
+		}
+	}
+	columns := make([]string, 0, len(columnSet))
+	for column := range columnSet {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	frame := data.NewFrame("response")
+	frame.Fields = append(frame.Fields, data.NewField(timeFieldName, nil, sampletime))
+	for _, column := range columns {
+		numbers := make([]*float64, len(rspDto.Data))
+		strs := make([]*string, len(rspDto.Data))
+		isNumeric := true
+		for i, datum := range rspDto.Data {
+			text, ok := datum.Metrics[column]
+			if !ok {
+				continue
+			}
+			strs[i] = &text
+			if number, err := strconv.ParseFloat(text, 64); err == nil {
+				numbers[i] = &number
+			} else if text != "N/A" {
+				isNumeric = false
+			}
+		}
+		if isNumeric {
+			frame.Fields = append(frame.Fields, data.NewField(column, nil, numbers))
+		} else {
+			frame.Fields = append(frame.Fields, data.NewField(column, nil, strs))
+		}
+	}
+	return frame, nil
+}
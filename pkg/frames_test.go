@@ -0,0 +1,238 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// The zone's data from an OPEN API response body.
+func decodeZoneData(t *testing.T, zone string, body string, metrics []string) *zoneData {
+	t.Helper()
+	rspDto, err := decodeGtmDnsTrafficAllPropertiesRspDto(strings.NewReader(body), log.DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zd, err := newZoneData(zone, rspDto, metrics, false, FIVE_MINUTES, log.DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zd
+}
+
+// The names of the frame's fields, in order.
+func fieldNames(fields []*data.Field) []string {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.Name
+	}
+	return names
+}
+
+func TestMetricFieldOrder(t *testing.T) {
+	// The response's keys are in neither of the requested orders. "other" stands for any column the report may return.
+	const body = `{"data": [{"hits": "10", "startdatetime": "1600000000000", "other": "2"},
+		{"other": "3", "hits": "20", "startdatetime": "1600000300000"}]}`
+	tests := []struct {
+		name       string
+		metrics    []string
+		wantFields []string
+		wantValues []float64 // the first row
+	}{
+		{name: "hits first", metrics: []string{"hits", "other"}, wantFields: []string{"time", "hits", "other"}, wantValues: []float64{10, 2}},
+		{name: "other first", metrics: []string{"other", "hits"}, wantFields: []string{"time", "other", "hits"}, wantValues: []float64{2, 10}},
+		{name: "one metric", metrics: []string{"other"}, wantFields: []string{"time", "other"}, wantValues: []float64{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zd := decodeZoneData(t, "example.akadns.net", body, tt.metrics)
+			for _, frame := range []*data.Frame{
+				wideFrame([]*zoneData{zd}, tt.metrics, "", "time", false, false, false),
+				longFrame([]*zoneData{zd}, tt.metrics, "", "time", false, false, false),
+			} {
+				names := fieldNames(frame.Fields)
+				if frame.Fields[1].Name == "zone" {
+					names = append(names[:1], names[2:]...) // the long frame's zone field
+				}
+				if strings.Join(names, ",") != strings.Join(tt.wantFields, ",") {
+					t.Fatalf("fields = %v, want %v", names, tt.wantFields)
+				}
+				for m, want := range tt.wantValues {
+					field := frame.Fields[len(frame.Fields)-len(tt.wantValues)+m]
+					if got, _ := field.ConcreteAt(0); got != want {
+						t.Errorf("%v = %v, want %v", field.Name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSeriesLabels(t *testing.T) {
+	const body = `{"data": [{"startdatetime": "1600000000000", "hits": "10", "other": "2"}]}`
+	metrics := []string{"hits", "other"}
+	zones := []*zoneData{
+		decodeZoneData(t, "a.akadns.net", body, metrics),
+		decodeZoneData(t, "b.akadns.net", body, metrics),
+	}
+	frame := wideFrame(zones, metrics, "", "time", false, false, false)
+
+	want := []data.Labels{
+		{"zone": "a.akadns.net", "metric": "hits"},
+		{"zone": "a.akadns.net", "metric": "other"},
+		{"zone": "b.akadns.net", "metric": "hits"},
+		{"zone": "b.akadns.net", "metric": "other"},
+	}
+	if len(frame.Fields) != 1+len(want) {
+		t.Fatalf("fields = %v, want time and %v series", fieldNames(frame.Fields), len(want))
+	}
+	for i, labels := range want {
+		field := frame.Fields[1+i]
+		if field.Labels.String() != labels.String() {
+			t.Errorf("field %v labels = %v, want %v", i+1, field.Labels, labels)
+		}
+		if field.Name != labels["metric"] {
+			t.Errorf("field %v name = %q, want %q", i+1, field.Name, labels["metric"])
+		}
+	}
+}
+
+// The "name (display name)" of each field.
+func seriesNames(fields []*data.Field) []string {
+	var names []string
+	for _, field := range fields {
+		displayName := ""
+		if field.Config != nil {
+			displayName = field.Config.DisplayNameFromDS
+		}
+		names = append(names, field.Name+" ("+displayName+")")
+	}
+	return names
+}
+
+func TestSeriesNames(t *testing.T) {
+	const body = `{"data": [{"startdatetime": "1600000000000", "hits": "10", "other": "2"}]}`
+	tests := []struct {
+		name           string
+		zones          []string
+		metrics        []string
+		userMetricName string
+		wantSeries     []string // the wide frame's value fields
+		wantSummary    []string // the summary frame's value fields
+	}{
+		{
+			name: "one zone", zones: []string{"a.akadns.net"}, metrics: []string{"hits"},
+			wantSeries: []string{"hits ()"}, wantSummary: []string{"Total hits ()"},
+		},
+		{
+			name: "one zone, two metrics", zones: []string{"a.akadns.net"}, metrics: []string{"hits", "other"},
+			wantSeries: []string{"hits ()", "other ()"}, wantSummary: []string{"Total hits ()", "Total other ()"},
+		},
+		{
+			name: "two zones", zones: []string{"a.akadns.net", "b.akadns.net"}, metrics: []string{"hits"},
+			wantSeries:  []string{"hits (a.akadns.net)", "hits (b.akadns.net)"},
+			wantSummary: []string{"Total hits (a.akadns.net Total hits)", "Total hits (b.akadns.net Total hits)"},
+		},
+		{
+			name: "two zones, two metrics", zones: []string{"a.akadns.net", "b.akadns.net"}, metrics: []string{"hits", "other"},
+			wantSeries: []string{"hits (a.akadns.net hits)", "other (a.akadns.net other)", "hits (b.akadns.net hits)", "other (b.akadns.net other)"},
+			wantSummary: []string{"Total hits (a.akadns.net Total hits)", "Total other (a.akadns.net Total other)",
+				"Total hits (b.akadns.net Total hits)", "Total other (b.akadns.net Total other)"},
+		},
+		{
+			name: "metric name", zones: []string{"a.akadns.net"}, metrics: []string{"hits"}, userMetricName: "DNS",
+			wantSeries: []string{"DNS ()"}, wantSummary: []string{"DNS ()"},
+		},
+		{
+			name: "metric name, two zones", zones: []string{"a.akadns.net", "b.akadns.net"}, metrics: []string{"hits"}, userMetricName: "DNS",
+			wantSeries: []string{"DNS ()", "DNS ()"}, wantSummary: []string{"DNS ()", "DNS ()"},
+		},
+		{
+			name: "metric name, two metrics", zones: []string{"a.akadns.net"}, metrics: []string{"hits", "other"}, userMetricName: "DNS",
+			wantSeries: []string{"DNS hits ()", "DNS other ()"}, wantSummary: []string{"DNS hits ()", "DNS other ()"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var zones []*zoneData
+			for _, zone := range tt.zones {
+				zones = append(zones, decodeZoneData(t, zone, body, tt.metrics))
+			}
+			series := seriesNames(wideFrame(zones, tt.metrics, tt.userMetricName, "time", false, false, false).Fields[1:])
+			if strings.Join(series, ",") != strings.Join(tt.wantSeries, ",") {
+				t.Errorf("series = %q, want %q", series, tt.wantSeries)
+			}
+			summary := summaryOnlyFrame(zones, tt.metrics, tt.userMetricName, false, TOTAL_NA_ZERO)
+			if got := seriesNames(summary.Fields); strings.Join(got, ",") != strings.Join(tt.wantSummary, ",") {
+				t.Errorf("summary = %q, want %q", got, tt.wantSummary)
+			}
+		})
+	}
+}
+
+func TestRowOrder(t *testing.T) {
+	// A response row: its time, in minutes after the start, and its hits.
+	type row struct {
+		minutes int
+		hits    string
+	}
+	tests := []struct {
+		name           string
+		rows           []row
+		duplicateTimes string
+		wantMinutes    []int
+		wantHits       []float64
+	}{
+		{"in order", []row{{0, "1"}, {5, "2"}, {10, "3"}}, DUPLICATE_TIMES_LAST, []int{0, 5, 10}, []float64{1, 2, 3}},
+		{"shuffled", []row{{10, "3"}, {0, "1"}, {5, "2"}}, DUPLICATE_TIMES_LAST, []int{0, 5, 10}, []float64{1, 2, 3}},
+		{"duplicate, last", []row{{0, "1"}, {5, "2"}, {5, "5"}}, DUPLICATE_TIMES_LAST, []int{0, 5}, []float64{1, 5}},
+		{"duplicate, sum", []row{{0, "1"}, {5, "2"}, {5, "5"}}, DUPLICATE_TIMES_SUM, []int{0, 5}, []float64{1, 7}},
+		{"duplicate, drop", []row{{0, "1"}, {5, "2"}, {5, "5"}}, DUPLICATE_TIMES_DROP, []int{0, 5}, []float64{1, 2}},
+		// Sorting keeps the response's order of rows with the same time: the last is still the last.
+		{"shuffled duplicate, last", []row{{5, "2"}, {0, "1"}, {5, "5"}}, DUPLICATE_TIMES_LAST, []int{0, 5}, []float64{1, 5}},
+	}
+	start := time.Unix(1600000000, 0).UTC()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []string
+			for _, r := range tt.rows {
+				startdatetime := start.Add(time.Duration(r.minutes)*time.Minute).UnixNano() / 1e6
+				items = append(items, fmt.Sprintf(`{"startdatetime": "%v", "hits": "%v"}`, startdatetime, r.hits))
+			}
+			zd := decodeZoneData(t, "example.akadns.net", `{"data": [`+strings.Join(items, ",")+`]}`, []string{"hits"})
+			zd.mergeDuplicateTimes(tt.duplicateTimes, log.DefaultLogger)
+
+			// The parallel slices stay in step.
+			if len(zd.sampletime) != len(tt.wantMinutes) || len(zd.metricValues[0]) != len(tt.wantHits) || len(zd.coverage) != len(tt.wantHits) {
+				t.Fatalf("times = %v, want %v rows", zd.sampletime, len(tt.wantMinutes))
+			}
+			for i, minutes := range tt.wantMinutes {
+				if want := start.Add(time.Duration(minutes) * time.Minute); !zd.sampletime[i].Equal(want) {
+					t.Errorf("time[%v] = %v, want %v", i, zd.sampletime[i], want)
+				}
+				if got := *zd.metricValues[0][i]; got != tt.wantHits[i] {
+					t.Errorf("hits[%v] = %v, want %v", i, got, tt.wantHits[i])
+				}
+			}
+		})
+	}
+}
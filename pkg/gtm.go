@@ -17,10 +17,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/akamai/AkamaiOPEN-edgegrid-golang/client-v1"
@@ -39,41 +45,191 @@ const GTM_POST_URL_FORMAT = "/reporting-api/v1/reports/load-balancing-dns-traffi
 const GTM_TEST_URL_FORMAT = "/reporting-api/v1/reports/load-balancing-dns-traffic-all-properties/versions/2/report-data?start=%v&end=%v&interval=%v&objectIds=%v"
 const FOUR_WEEKS = 4 * 7 * 24 // four weeks as hours
 const NINETY_DAYS = 90 * 24 * time.Hour
+const DEFAULT_DATA_POINT_LIMIT = 100000
+
+// The largest response body read, so a pathological response can't exhaust memory.
+const DEFAULT_MAX_RESPONSE_BYTES = 64 << 20 // 64 MiB
+
+// The clock. Tests can replace it to freeze time, e.g. at the 90-day retention boundary.
+var timeNow = time.Now
 
 type Interval string
 
 const (
 	HOUR         Interval = "HOUR"
-	FIVE_MINUTES          = "FIVE_MINUTES"
+	FIVE_MINUTES Interval = "FIVE_MINUTES"
 )
 
-func calculateInterval(from time.Time, to time.Time, maxDataPoints uint) Interval {
+// The length of each supported interval.
+var intervalDurations = map[Interval]time.Duration{
+	FIVE_MINUTES: 5 * time.Minute,
+	HOUR:         time.Hour,
+}
+
+// The length of the interval. 0 if the interval isn't supported.
+func (interval Interval) Duration() time.Duration {
+	return intervalDurations[interval]
+}
+
+// The number of the interval's datapoints in the time range, counting a partial interval.
+func (interval Interval) buckets(from time.Time, to time.Time) uint {
+	if !to.After(from) {
+		return 0
+	}
+	d := interval.Duration()
+	return uint((to.Sub(from) + d - 1) / d)
+}
+
+// Is the interval supported by the OPEN API?
+func (interval Interval) Valid() bool {
+	_, ok := intervalDurations[interval]
+	return ok
+}
+
+// When HOUR is chosen instead of FIVE_MINUTES. Tunable for API tiers with other limits.
+type intervalThresholds struct {
+	hourOnlyHours   uint          // time ranges over this many hours must use HOUR. Default: FOUR_WEEKS
+	hourOnlyMargin  time.Duration // time ranges within this of hourOnlyHours use HOUR too. Default: DEFAULT_HOUR_ONLY_MARGIN
+	hourlyFillRatio float64       // use HOUR if the FIVE_MINUTES datapoints are over this fraction of the max datapoints. Default: 1
+}
+
+// Aligning the time range to interval boundaries may lengthen it, so ranges just under four weeks use HOUR too.
+const DEFAULT_HOUR_ONLY_MARGIN = time.Hour
+
+var defaultIntervalThresholds = intervalThresholds{
+	hourOnlyHours:   FOUR_WEEKS,
+	hourOnlyMargin:  DEFAULT_HOUR_ONLY_MARGIN,
+	hourlyFillRatio: 1,
+}
+
+// Must the time range use HOUR? It is over the hour-only limit, or within the margin below it.
+func (thresholds intervalThresholds) hourOnly(from time.Time, to time.Time) bool {
+	return to.Sub(from) > time.Duration(thresholds.hourOnlyHours)*time.Hour-thresholds.hourOnlyMargin
+}
+
+// Also returns the reason for the choice.
+func calculateInterval(from time.Time, to time.Time, maxDataPoints uint, thresholds intervalThresholds, logger log.Logger) (Interval, string) {
+	interval, reason := chooseInterval(from, to, maxDataPoints, thresholds)
+	logger.Debug("calculateInterval", "fiveMinuteBuckets", FIVE_MINUTES.buckets(from, to), "maxDataPoints", maxDataPoints,
+		"interval", interval, "reason", reason)
+	return interval, reason
+}
+
+func chooseInterval(from time.Time, to time.Time, maxDataPoints uint, thresholds intervalThresholds) (Interval, string) {
 	// Must use HOUR interval for time ranges over 4 weeks.
-	timeRangeHours := uint(to.Sub(from).Hours())
-	if timeRangeHours > FOUR_WEEKS {
-		return HOUR
+	if thresholds.hourOnly(from, to) {
+		queryAdjustmentsTotal.WithLabelValues(ADJUSTED_LONG_RANGE).Inc()
+		return HOUR, fmt.Sprintf("time range is over %v hours, less a margin of %v", thresholds.hourOnlyHours, thresholds.hourOnlyMargin)
+	}
+
+	// Use FIVE_MINUTES if its datapoints fit the graph, else HOUR.
+	fiveMinuteBuckets := FIVE_MINUTES.buckets(from, to)
+	fits := thresholds.hourlyFillRatio * float64(maxDataPoints)
+	if float64(fiveMinuteBuckets) > fits {
+		queryAdjustmentsTotal.WithLabelValues(ADJUSTED_MAX_DATA_POINTS).Inc()
+		return HOUR, fmt.Sprintf("%v five-minute datapoints overfill the %v max datapoints; %v hourly datapoints are used",
+			fiveMinuteBuckets, maxDataPoints, HOUR.buckets(from, to))
+	}
+	return FIVE_MINUTES, fmt.Sprintf("%v five-minute datapoints fit the %v max datapoints", fiveMinuteBuckets, maxDataPoints)
+}
+
+// Parse a Grafana-style duration, e.g. "15m", "1h" or "1d".
+func parseGrafanaDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * unit, nil
+		}
 	}
+	return time.ParseDuration(s)
+}
 
-	// If there are enough 1-hour datapoints to fill the graph then use HOUR
-	if timeRangeHours >= maxDataPoints {
-		return HOUR
+// The supported interval nearest to the requested duration.
+// If the interval isn't exactly what was requested, also returns a message saying why.
+func intervalFromDuration(requested time.Duration, from time.Time, to time.Time, thresholds intervalThresholds) (Interval, string) {
+	interval := FIVE_MINUTES
+	if requested-5*time.Minute > time.Hour-requested {
+		interval = HOUR
 	}
 
-	// Else use FIVE_MINUTES
-	return FIVE_MINUTES
+	// Must use HOUR interval for time ranges over 4 weeks.
+	if interval == FIVE_MINUTES && thresholds.hourOnly(from, to) {
+		return HOUR, fmt.Sprintf("Interval %v is not available for time ranges over %v hours, less a margin of %v. Using HOUR.",
+			requested, thresholds.hourOnlyHours, thresholds.hourOnlyMargin)
+	}
+
+	if requested != interval.Duration() {
+		return interval, fmt.Sprintf("Interval %v is not supported. Using the nearest supported interval, %v.", requested, interval)
+	}
+	return interval, ""
+}
+
+// How times are rounded to interval boundaries.
+type Rounding string
+
+const (
+	ROUND_NEAREST Rounding = "nearest"
+	ROUND_FLOOR   Rounding = "floor"
+	ROUND_CEIL    Rounding = "ceil"
+	// For a time range: floor the start and ceil the end, so the whole time range is covered.
+	ROUND_OUTWARD Rounding = "outward"
+)
+
+// The rounding of the start (from) and end (to) of a time range.
+func timeRangeRounding(rounding Rounding) (Rounding, Rounding) {
+	if rounding == ROUND_OUTWARD {
+		return ROUND_FLOOR, ROUND_CEIL
+	}
+	return rounding, rounding
 }
 
 // GTM OPEN API insists that start and end times must be on interval boundaries.
-func roundupTimeForInterval(t time.Time, interval Interval) time.Time {
-	switch interval {
-	case FIVE_MINUTES:
-		return t.Round(5 * time.Minute)
-	case HOUR:
-		return t.Round(time.Hour)
+// Fails for an unsupported interval: an unrounded time would be a subtly wrong request.
+func roundupTimeForInterval(t time.Time, interval Interval, rounding Rounding) (time.Time, error) {
+	if !interval.Valid() {
+		return t, errors.New("Unsupported interval: " + string(interval))
+	}
+	d := interval.Duration()
+
+	switch rounding {
+	case ROUND_FLOOR:
+		return t.Truncate(d), nil
+	case ROUND_CEIL:
+		if truncated := t.Truncate(d); truncated.Before(t) {
+			return truncated.Add(d), nil
+		}
+		return t, nil
 	default:
-		log.DefaultLogger.Error("roundupTimeForInterval", "unsupported interval:", interval)
-		return t
+		return t.Round(d), nil
+	}
+}
+
+// The number of data rows the OPEN API returns for the time range.
+func estimateDataRows(fromRounded time.Time, toRounded time.Time, interval Interval) int {
+	duration := interval.Duration()
+	if duration == 0 {
+		return 0
 	}
+	return int(toRounded.Sub(fromRounded) / duration)
+}
+
+// Refuse a query whose frame would be too big for the browser to render: numSeries series over the time range.
+func checkDataPointLimit(fromRounded time.Time, toRounded time.Time, interval Interval, numSeries int, dataPointLimit uint, logger log.Logger) error {
+	estimatedDataPoints := estimateDataRows(fromRounded, toRounded, interval) * numSeries
+	if estimatedDataPoints <= int(dataPointLimit) {
+		return nil
+	}
+	logger.Debug("checkDataPointLimit", "estimatedDataPoints", estimatedDataPoints, "dataPointLimit", dataPointLimit)
+	return fmt.Errorf("Query would return about %v data points, more than the limit of %v. Narrow the time range or reduce the number of zones",
+		estimatedDataPoints, dataPointLimit)
+}
+
+// Is the interval starting at 'start' still being collected?
+func intervalIsIncomplete(start time.Time, interval Interval, now time.Time) bool {
+	return start.Add(interval.Duration()).After(now)
 }
 
 // Is the time before the oldest available data?
@@ -89,31 +245,77 @@ func limitTimeToOldestData(timeRounded time.Time, oldestDataTime time.Time) time
 	return timeRounded
 }
 
+// The time range of the last n complete intervals, ending where the interval still being collected starts.
+// Data within the data delay is still being collected.
+func lastIntervalsTimeRange(n uint, interval Interval, now time.Time, dataDelay time.Duration) (time.Time, time.Time) {
+	to := now.Add(-dataDelay).Truncate(interval.Duration())
+	return to.Add(-time.Duration(n) * interval.Duration()), to
+}
+
 // Adjust the start (from) and end (to) times
-func adjustQueryTimes(from time.Time, to time.Time, interval Interval) (time.Time, time.Time, error) {
-	fromRounded := roundupTimeForInterval(from, interval)
-	toRounded := roundupTimeForInterval(to, interval)
+func adjustQueryTimes(from time.Time, to time.Time, interval Interval, dataDelay time.Duration, maxLookback time.Duration,
+	rounding Rounding, noClamp bool, logger log.Logger) (time.Time, time.Time, error) {
+	fromRounding, toRounding := timeRangeRounding(rounding)
+	fromRounded, err := roundupTimeForInterval(from, interval, fromRounding)
+	if err != nil {
+		logger.Error("adjustQueryTimes", "err", err)
+		return from, to, err
+	}
+	// The interval is valid: the other times are rounded without error.
+	toRounded, _ := roundupTimeForInterval(to, interval, toRounding)
+
+	// The most recent data is still being collected. Stop short of it, excluding the incomplete interval.
+	if dataDelay > 0 {
+		latestComplete, _ := roundupTimeForInterval(timeNow().Add(-dataDelay), interval, ROUND_FLOOR)
+		if toRounded.After(latestComplete) {
+			logger.Debug("adjustQueryTimes", "dataDelay", dataDelay, "to", latestComplete)
+			toRounded = latestComplete
+		}
+		if !toRounded.After(fromRounded) {
+			err := errors.New("Time range is within the configured data delay")
+			logger.Debug("adjustQueryTimes", "err", err)
+			return fromRounded, toRounded, err
+		}
+	}
 
-	// Data is available from the OPEN API for 90 days
-	ninetyDaysAgo := roundupTimeForInterval(time.Now().Add(-NINETY_DAYS), interval)
+	// Data is available from the OPEN API for 90 days, or less if the datasource limits it.
+	// Round up: rounding down would be before the oldest data.
+	oldestDataTime, _ := roundupTimeForInterval(timeNow().Add(-maxLookback), interval, ROUND_CEIL)
 
 	// Is the 'to' (end) time before data is available?  If so, that's an error.
-	if timeBeforeOldestData(toRounded, ninetyDaysAgo) {
+	if timeBeforeOldestData(toRounded, oldestDataTime) {
 		err := errors.New("Time range is before available data")
-		log.DefaultLogger.Info("adjustQueryTimes", "err", err)
+		logger.Debug("adjustQueryTimes", "err", err)
+		return fromRounded, toRounded, err
+	}
+
+	// Fail rather than return less data than was asked for.
+	if noClamp && timeBeforeOldestData(fromRounded, oldestDataTime) {
+		err := fmt.Errorf("Time range starts before available data. Data is available from %v", oldestDataTime.Format(time.RFC3339))
+		logger.Debug("adjustQueryTimes", "err", err)
 		return fromRounded, toRounded, err
 	}
 
 	// Limit the 'from' (start) time to when the oldest data is available.
-	fromLimited := limitTimeToOldestData(fromRounded, ninetyDaysAgo)
+	fromLimited := limitTimeToOldestData(fromRounded, oldestDataTime)
 
 	// Returned the fixed 'to' and 'from' times.
 	return fromLimited, toRounded, nil
 }
 
-// The time format required by OPEN API
+// A lookback for the user, e.g. "90 days" or "36h0m0s".
+func formatLookback(lookback time.Duration) string {
+	const day = 24 * time.Hour
+	if lookback%day == 0 {
+		return fmt.Sprintf("%v days", int64(lookback/day))
+	}
+	return lookback.String()
+}
+
+// The time format required by OPEN API, e.g. 2021-03-01T10:00:00Z
+// Always UTC: the same instant in whatever zone the time carries, and never a server-dependent offset.
 func openApiUrlTimeFormat(t time.Time) string {
-	return url.QueryEscape(t.Format(time.RFC3339))
+	return url.QueryEscape(t.UTC().Format(time.RFC3339))
 }
 
 // OPEN API URLs
@@ -122,7 +324,7 @@ func createPostOpenUrl(fromRounded time.Time, toRounded time.Time, interval Inte
 }
 
 func createTestOpenUrl(fromRounded time.Time, toRounded time.Time, interval Interval, zone string) string {
-	return fmt.Sprintf(GTM_TEST_URL_FORMAT, openApiUrlTimeFormat(fromRounded), openApiUrlTimeFormat(toRounded), interval, zone)
+	return fmt.Sprintf(GTM_TEST_URL_FORMAT, openApiUrlTimeFormat(fromRounded), openApiUrlTimeFormat(toRounded), interval, url.QueryEscape(zone))
 }
 
 // EdgeGrid configuration structure constructor
@@ -142,26 +344,113 @@ func NewEdgegridConfig(clientSecret string, host string, accessToken string, cli
 // Example request bodies:
 // {"objectType": "fpdomain", "objectIds": ["akamccare.akadns.net"], "metrics": ["startdatetime", "hits"]}
 
+const START_DATE_TIME_METRIC = "startdatetime"
+const DEFAULT_METRIC = "hits"
+
+// A metric the report returns, for the query editor. Unit is a Grafana unit, e.g. "percent".
+type metricInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Unit        string `json:"unit"`
+}
+
+const FPDOMAIN_OBJECT_TYPE = "fpdomain"
+
+// The metrics of each object type of the report.
+var supportedMetrics = map[string][]metricInfo{
+	FPDOMAIN_OBJECT_TYPE: {
+		{Name: DEFAULT_METRIC, Description: "DNS requests answered", Unit: "short"},
+	},
+}
+
 // OPEN API request body contructor
-func NewGtmDnsTrafficAllPropertiesReqDto(zoneName []string) *GtmDnsTrafficAllPropertiesReqDto {
+func NewGtmDnsTrafficAllPropertiesReqDto(zoneName []string, metrics []string) *GtmDnsTrafficAllPropertiesReqDto {
 	return &GtmDnsTrafficAllPropertiesReqDto{
-		ObjectType: "fpdomain",
+		ObjectType: FPDOMAIN_OBJECT_TYPE,
 		ObjectIds:  zoneName,
-		Metrics:    []string{"startdatetime", "hits"},
+		Metrics:    append([]string{START_DATE_TIME_METRIC}, metrics...),
 	}
 }
 
 type GtmDnsTrafficAllPropertiesReqDto struct {
-	ObjectType string   `json:"objectType"`
-	ObjectIds  []string `json:"objectIds"`
-	Metrics    []string `json:"metrics"`
+	ObjectType string              `json:"objectType"`
+	ObjectIds  []string            `json:"objectIds"`
+	Metrics    []string            `json:"metrics"`
+	Filters    map[string][]string `json:"filters,omitempty"` // only in request bodies supplied by the user
+}
+
+// A request body supplied by the user, e.g. with filters the plugin doesn't model.
+// Only the request DTO's fields are allowed, so the body can't smuggle anything else to the OPEN API.
+func parseRequestBody(requestBody json.RawMessage) (*GtmDnsTrafficAllPropertiesReqDto, error) {
+	var reqDto GtmDnsTrafficAllPropertiesReqDto
+	decoder := json.NewDecoder(bytes.NewReader(requestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&reqDto); err != nil {
+		return nil, errors.New("Invalid request body: " + err.Error())
+	}
+
+	if reqDto.ObjectType != FPDOMAIN_OBJECT_TYPE {
+		return nil, errors.New("Invalid request body: objectType must be fpdomain")
+	}
+	if len(reqDto.ObjectIds) == 0 {
+		return nil, errors.New("Invalid request body: objectIds is empty")
+	}
+	if len(reqDto.Metrics) == 0 {
+		return nil, errors.New("Invalid request body: metrics is empty")
+	}
+	hasStartDateTime := false
+	for _, metric := range reqDto.Metrics {
+		if len(metric) == 0 {
+			return nil, errors.New("Invalid request body: empty metric")
+		}
+		hasStartDateTime = hasStartDateTime || metric == START_DATE_TIME_METRIC
+	}
+	// The time of each row is needed to graph it.
+	if !hasStartDateTime {
+		reqDto.Metrics = append([]string{START_DATE_TIME_METRIC}, reqDto.Metrics...)
+	}
+	return &reqDto, nil
 }
 
 // OPEN API NORMAL RESPONSE
 
 type Datum struct {
-	StartDateTime string `json:"startdatetime"`
-	Hits          string `json:"hits"`
+	StartDateTime string            // "startdatetime"
+	Metrics       map[string]string // the other metrics, e.g. "hits", keyed by name
+}
+
+// The start of the row's interval, from its Unix time in milliseconds, keeping any
+// sub-second remainder.
+// UTC, whatever the server's time zone: Grafana converts it to the dashboard's.
+func (d *Datum) startTime() (time.Time, error) {
+	unixms, err := strconv.ParseInt(d.StartDateTime, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unixms/1000, (unixms%1000)*1e6).UTC(), nil
+}
+
+// A data row is an object keyed by metric name. The key order varies.
+func (d *Datum) UnmarshalJSON(b []byte) error {
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(b, &row); err != nil {
+		return err
+	}
+
+	d.Metrics = make(map[string]string, len(row))
+	for metric, raw := range row {
+		// Values are usually strings, e.g. "1234" or "N/A". Keep numbers as their JSON text.
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		if metric == START_DATE_TIME_METRIC {
+			d.StartDateTime = value
+		} else {
+			d.Metrics[metric] = value
+		}
+	}
+	return nil
 }
 
 type Metadata struct {
@@ -178,9 +467,62 @@ type Metadata struct {
 }
 
 type GtmDnsTrafficAllPropertiesRspDto struct {
-	Data     []Datum  `json:"data"`
-	Metadata Metadata `json:"metadata"`
-	// `json:"summaryStatistics"`
+	Data              []Datum                    `json:"data"`
+	Metadata          Metadata                   `json:"metadata"`
+	SummaryStatistics map[string]json.RawMessage `json:"summaryStatistics"`
+	RawBody           []byte                     `json:"-"` // the response body, for debugging
+}
+
+// Decode the POST response body.
+// Only the data is required. The metadata and summary statistics are decoded leniently,
+// so a change in their schema doesn't stop the data from being graphed.
+func decodeGtmDnsTrafficAllPropertiesRspDto(body io.Reader, logger log.Logger) (*GtmDnsTrafficAllPropertiesRspDto, error) {
+	var rawRspDto struct {
+		Data              []Datum         `json:"data"`
+		Metadata          json.RawMessage `json:"metadata"`
+		SummaryStatistics json.RawMessage `json:"summaryStatistics"`
+	}
+	if err := json.NewDecoder(body).Decode(&rawRspDto); err != nil {
+		return nil, err
+	}
+
+	rspDto := &GtmDnsTrafficAllPropertiesRspDto{Data: rawRspDto.Data}
+	if len(rawRspDto.Metadata) > 0 {
+		// Keep whatever could be decoded.
+		if err := json.Unmarshal(rawRspDto.Metadata, &rspDto.Metadata); err != nil {
+			logger.Warn("Error decoding response metadata", "err", err)
+		}
+	}
+	if len(rawRspDto.SummaryStatistics) > 0 {
+		if err := json.Unmarshal(rawRspDto.SummaryStatistics, &rspDto.SummaryStatistics); err != nil {
+			logger.Warn("Error decoding response summary statistics", "err", err)
+			rspDto.SummaryStatistics = nil
+		}
+	}
+	return rspDto, nil
+}
+
+// A summary statistic is a number, a string, or an object with a "value".
+// Returns false if the statistic isn't numeric (e.g. "N/A").
+func summaryStatisticValue(raw json.RawMessage) (float64, bool) {
+	var stat struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &stat); err == nil && stat.Value != nil {
+		raw = stat.Value
+	}
+
+	var number float64
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return number, true
+	}
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		if number, err := strconv.ParseFloat(str, 64); err == nil {
+			return number, true
+		}
+	}
+	return 0, false
 }
 
 // OPEN API ERROR RESPONSE
@@ -191,61 +533,383 @@ type Error struct {
 }
 
 type OpenApiErrorRspDto struct {
-	Errors   []Error `json:"errors"`
-	Instance string  `json:"instance"`
-	Title    string  `json:"title"`
-	Type     string  `json:"type"`
+	Errors    []Error `json:"errors"`
+	Instance  string  `json:"instance"`
+	Title     string  `json:"title"`
+	Type      string  `json:"type"`
+	RequestId string  `json:"requestId"`
+}
+
+// The first error's title, else the response's title, e.g. for EdgeGrid authentication errors, which have no errors list.
+func (rspDto OpenApiErrorRspDto) message() string {
+	if len(rspDto.Errors) > 0 {
+		return rspDto.Errors[0].Title
+	}
+	return rspDto.Title
+}
+
+// Is it a maintenance response? A 503, or an error whose type or title mentions maintenance.
+func isMaintenanceResponse(statusCode int, rspDto OpenApiErrorRspDto) bool {
+	if statusCode == http.StatusServiceUnavailable {
+		return true
+	}
+	for _, text := range []string{rspDto.Type, rspDto.Title, rspDto.message()} {
+		if strings.Contains(strings.ToLower(text), "maintenance") {
+			return true
+		}
+	}
+	return false
+}
+
+// The error's type, instance and request ID, for verbose error messages. Akamai support asks for them.
+// "" if the response has none of them.
+func (rspDto OpenApiErrorRspDto) details() string {
+	errorType := rspDto.Type
+	if len(rspDto.Errors) > 0 && len(rspDto.Errors[0].Type) > 0 {
+		errorType = rspDto.Errors[0].Type
+	}
+	var details []string
+	for _, detail := range []struct{ name, value string }{
+		{"type", errorType},
+		{"instance", rspDto.Instance},
+		{"request ID", rspDto.RequestId},
+	} {
+		if len(detail.value) > 0 {
+			details = append(details, detail.name+": "+detail.value)
+		}
+	}
+	return strings.Join(details, ", ")
+}
+
+// The message with the error's details appended if verbose error messages are on.
+func withErrorDetails(msg string, rspDto OpenApiErrorRspDto, verbose bool) string {
+	if details := rspDto.details(); verbose && len(details) > 0 {
+		return msg + " (" + details + ")"
+	}
+	return msg
+}
+
+// EdgeGrid rejects a request whose signature it can't verify with a 401, e.g. for a malformed client secret.
+// Returns a user-friendly error saying which credential is most likely at fault, or nil if it's not a 401.
+func credentialSigningError(statusCode int, title string) error {
+	if statusCode != 401 {
+		return nil
+	}
+	field := "the client secret and tokens"
+	lowerTitle := strings.ToLower(title)
+	switch {
+	case strings.Contains(lowerTitle, "signature"):
+		field = "the client secret"
+	case strings.Contains(lowerTitle, "client token"):
+		field = "the client token"
+	case strings.Contains(lowerTitle, "access token"):
+		field = "the access token"
+	case strings.Contains(lowerTitle, "timestamp"):
+		field = "the Grafana server's clock"
+	}
+	return errors.New("Credential signing failed. Check " + field + ": " + title)
+}
+
+// The OPEN API may return data for different objects than requested.
+// Zone names are case-insensitive: the API returning them in its canonical case is no difference.
+// Returns a description of the difference, or "" if there is none.
+func objectIdsMismatch(requested []string, returned []string) string {
+	if len(returned) == 0 {
+		return "" // nothing to compare
+	}
+	returnedSet := make(map[string]bool, len(returned))
+	for _, objectId := range returned {
+		returnedSet[strings.ToLower(objectId)] = true
+	}
+	requestedSet := make(map[string]bool, len(requested))
+	for _, objectId := range requested {
+		requestedSet[strings.ToLower(objectId)] = true
+	}
+
+	same := len(requestedSet) == len(returnedSet)
+	for objectId := range requestedSet {
+		same = same && returnedSet[objectId]
+	}
+	if same {
+		return ""
+	}
+	return fmt.Sprintf("Requested %v but the API returned data for %v. Check the spelling of the zone names.", requested, returned)
 }
 
 // OPEN API REQUEST METHODS
 
+// How to reach the OPEN API.
+type openApiSettings struct {
+	clientSecret            string
+	host                    string
+	accessToken             string
+	clientToken             string
+	backupHosts             []string          // tried in order when the host fails
+	failoverOn              string            // when to try the next host: FAILOVER_ON_CONNECTION or FAILOVER_ON_SERVER
+	correlationHeader       string            // carries the correlation ID, if the context has one
+	customHeaders           map[string]string // added to every request, e.g. for a corporate gateway
+	httpClient              *http.Client      // the datasource instance's client
+	responseCache           *responseCache    // the datasource instance's cached responses, or the shared cache. nil: disabled
+	rateLimiter             *rateLimiter      // the datasource instance's request pacing. nil: no limit
+	circuitBreaker          *circuitBreaker   // the datasource instance's. nil: never open
+	maxResponseBytes        int64             // larger responses are refused rather than decoded
+	retryTruncatedResponses bool              // retry a query while its response is cut short, within the retry policy
+	retryPolicy             retryPolicy       // how long retries may wait and take
+	requestTimeout          requestTimeout    // each query request's timeout, scaled with its rows
+	verboseErrors           bool              // add the API error's type, instance and request ID to error messages
+	fieldMapping            map[string]string // the API's name of each renamed metric, e.g. {"hits": "requests"}
+	stats                   *queryStats       // the query's API requests. nil: not recorded
+	bypassCache             bool              // re-fetch instead of using cached responses. Fresh responses are still cached
+}
+
+// Headers set by EdgeGrid signing or by the plugin. Custom headers can't replace them.
+var reservedHeaders = []string{"Authorization", "Host", "Content-Type", "Content-Length", "User-Agent"}
+
+// Custom headers must not clobber the reserved headers or the correlation header.
+func validateCustomHeaders(customHeaders map[string]string, correlationHeader string) error {
+	for name := range customHeaders {
+		canonicalName := http.CanonicalHeaderKey(name)
+		if len(canonicalName) == 0 {
+			return errors.New("Invalid custom header: empty name")
+		}
+		for _, reserved := range append(reservedHeaders, correlationHeader) {
+			if canonicalName == http.CanonicalHeaderKey(reserved) {
+				return errors.New("Custom header not allowed: " + name)
+			}
+		}
+	}
+	return nil
+}
+
+// When a request is sent to the next host.
+const (
+	FAILOVER_ON_CONNECTION = "connection" // the host can't be reached
+	FAILOVER_ON_SERVER     = "server"     // the host can't be reached or responds with a 5xx status
+)
+
+// Create, sign and send a request to the OPEN API, with any request-specific headers.
+// If the host fails, the request is sent to the backup hosts in turn.
+func sendOpenApiRequest(ctx context.Context, settings openApiSettings, method string, openurl string, body []byte,
+	header http.Header) (*http.Response, error) {
+	// Fail fast while the API is failing.
+	if err := settings.circuitBreaker.allow(); err != nil {
+		contextLogger(ctx).Info("sendOpenApiRequest", "circuitBreaker", err)
+		return nil, err
+	}
+
+	hosts := append([]string{settings.host}, settings.backupHosts...)
+	var apiresp *http.Response
+	var err error
+	for i, host := range hosts {
+		apiresp, err = sendOpenApiRequestToHost(ctx, settings, host, method, openurl, body, header)
+		if i == len(hosts)-1 || !shouldFailOver(ctx, settings.failoverOn, apiresp, err) {
+			break
+		}
+		contextLogger(ctx).Warn("sendOpenApiRequest", "failedHost", host, "nextHost", hosts[i+1], "err", err)
+		if apiresp != nil {
+			apiresp.Body.Close()
+		}
+	}
+
+	if err != nil && ctx.Err() != nil {
+		settings.circuitBreaker.release()
+	} else {
+		settings.circuitBreaker.record(err != nil || apiresp.StatusCode >= 500)
+	}
+	return apiresp, err
+}
+
+// Should the request be sent to the next host? Not if it was canceled: the next host would fail the same way.
+func shouldFailOver(ctx context.Context, failoverOn string, apiresp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	return failoverOn == FAILOVER_ON_SERVER && apiresp.StatusCode >= 500
+}
+
+func sendOpenApiRequestToHost(ctx context.Context, settings openApiSettings, host string, method string, openurl string,
+	body []byte, header http.Header) (*http.Response, error) {
+	logger := contextLogger(ctx)
+	config := NewEdgegridConfig(settings.clientSecret, host, settings.accessToken, settings.clientToken)
+
+	// Sign the request once it's allowed: the signature includes a timestamp.
+	if err := settings.rateLimiter.wait(ctx); err != nil {
+		logger.Info("sendOpenApiRequest", "rateLimiter", err)
+		return nil, err
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewBuffer(body)
+	}
+	apireq, err := client.NewRequest(*config, method, openurl, bodyReader)
+	if err != nil {
+		logger.Error("Error creating "+method+" request", "err", err)
+		return nil, fmt.Errorf("Failed to create the %v request: %w", method, err)
+	}
+	apireq = apireq.WithContext(ctx)
+
+	// Custom headers first, so they can't replace the plugin's. The signature is added last.
+	for name, value := range settings.customHeaders {
+		apireq.Header.Set(name, value)
+	}
+	for name, values := range header {
+		apireq.Header[name] = values
+	}
+	if correlationId := correlationIdFromContext(ctx); len(correlationId) > 0 {
+		apireq.Header.Set(settings.correlationHeader, correlationId)
+	}
+
+	apireq = edgegrid.AddRequestHeader(*config, apireq)
+	apiresp, err := settings.httpClient.Do(apireq)
+	if err != nil {
+		logger.Error("OPEN API communication error", "err", err)
+		return nil, err
+	}
+	return apiresp, nil
+}
+
+// The query succeeded but there is no data for the time range.
+var ErrNoData = errors.New("No data for the time range")
+
+// The API is down for Akamai maintenance. Nothing is wrong with the configuration: try again later.
+var ErrApiMaintenance = errors.New("Reporting API is temporarily unavailable (maintenance)")
+
+// The credentials aren't authorized for some of the requested zones.
+var ErrUnauthorizedObjects = errors.New("Some of the requested objects are unauthorized")
+
+// EdgeGrid rejects requests signed more than about 30 seconds off its clock.
+const DEFAULT_MAX_CLOCK_SKEW = 30 * time.Second
+
+// A warning if the local clock differs from the OPEN API's by more than maxClockSkew, else "".
+// The API's clock is its response's Date header, which has a 1-second resolution.
+func clockSkewWarning(dateHeader string, now time.Time, maxClockSkew time.Duration) string {
+	serverTime, err := http.ParseTime(dateHeader)
+	if err != nil {
+		return ""
+	}
+	skew := now.Sub(serverTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew <= maxClockSkew {
+		return ""
+	}
+	log.DefaultLogger.Warn("clockSkewWarning", "skew", skew, "serverTime", serverTime)
+	return fmt.Sprintf("Warning: the Grafana server's clock is %v off the API's. Requests signed with a skewed clock are rejected",
+		skew.Round(time.Second))
+}
+
+// Verify that the datasource can reach the OPEN API through each host, reporting each host's status.
+// Failing if any host fails: a backup host is only useful if it works.
+func gtmOpenApiHostsHealthCheck(ctx context.Context, settings openApiSettings, maxClockSkew time.Duration) (string, backend.HealthStatus) {
+	if len(settings.backupHosts) == 0 {
+		return gtmOpenApiHealthCheck(ctx, settings, maxClockSkew)
+	}
+
+	var messages []string
+	status := backend.HealthStatusOk
+	for _, host := range append([]string{settings.host}, settings.backupHosts...) {
+		hostSettings := settings
+		hostSettings.host = host
+		hostSettings.backupHosts = nil
+		msg, hostStatus := gtmOpenApiHealthCheck(ctx, hostSettings, maxClockSkew)
+		messages = append(messages, host+": "+msg)
+		if hostStatus != backend.HealthStatusOk {
+			status = hostStatus
+		}
+	}
+	return strings.Join(messages, "; "), status
+}
+
 // Verify that the datasource can reach the OPEN API
-func gtmOpenApiHealthCheck(clientSecret string, host string, accessToken string, clientToken string) (string, backend.HealthStatus) {
+func gtmOpenApiHealthCheck(ctx context.Context, settings openApiSettings, maxClockSkew time.Duration) (string, backend.HealthStatus) {
 
-	to := time.Now()                 // now
+	to := timeNow()                  // now
 	from := to.Add(-5 * time.Minute) // five minutes ago
-	interval := Interval(FIVE_MINUTES)
+	interval := FIVE_MINUTES
 
-	fromRounded := roundupTimeForInterval(from, interval)
-	toRounded := roundupTimeForInterval(to, interval)
+	fromRounded, err := roundupTimeForInterval(from, interval, ROUND_NEAREST)
+	if err != nil {
+		return err.Error(), backend.HealthStatusError
+	}
+	toRounded, _ := roundupTimeForInterval(to, interval, ROUND_NEAREST)
 	openurl := createTestOpenUrl(fromRounded, toRounded, interval, "-fake-") // The URL
 	log.DefaultLogger.Info("gtmOpenApiHealthCheck", "openurl", openurl)
 
-	config := NewEdgegridConfig(clientSecret, host, accessToken, clientToken)
-
 	// Send GET request to the OPEN API
-	apireq, err := client.NewRequest(*config, "GET", openurl, nil)
+	start := time.Now()
+	apiresp, err := sendOpenApiRequest(ctx, settings, "GET", openurl, nil, nil)
 	if err != nil {
-		log.DefaultLogger.Error("Error creating GET request", "err", err)
-		return err.Error(), backend.HealthStatusError
-	}
-	apiresp, err := client.Do(*config, apireq)
-	if err != nil {
-		log.DefaultLogger.Error("OPEN API communication error", "err", err)
-		return err.Error(), backend.HealthStatusError
+		return err.Error() + ". " + healthCheckDiagnostics(settings, interval, 0), backend.HealthStatusError
 	}
+	defer apiresp.Body.Close()
+	latency := time.Since(start)
 
 	log.DefaultLogger.Info("gtmOpenApiHealthCheck", "Status (403 expected)", apiresp.Status)
 
+	// A skewed clock makes EdgeGrid reject the signature, which looks like bad credentials. Explain it.
+	msg, status := healthCheckResponse(apiresp, settings.verboseErrors)
+	if warning := clockSkewWarning(apiresp.Header.Get("Date"), timeNow(), maxClockSkew); len(warning) > 0 {
+		msg += ". " + warning
+	}
+	return msg + ". " + healthCheckDiagnostics(settings, interval, latency), status
+}
+
+// A one-glance summary of the configuration the health check used, e.g. for screenshots in support tickets.
+// The round trip is omitted if 0: there was no response.
+func healthCheckDiagnostics(settings openApiSettings, interval Interval, latency time.Duration) string {
+	onOff := map[bool]string{true: "on", false: "off"}
+	diagnostics := []string{
+		"Host: " + settings.host,
+		"probe interval: " + string(interval),
+	}
+	if latency > 0 {
+		diagnostics = append(diagnostics, "round trip: "+latency.Round(time.Millisecond).String())
+	}
+	diagnostics = append(diagnostics,
+		"response cache: "+responseCacheDiagnostics(settings.responseCache),
+		"retry truncated responses: "+onOff[settings.retryTruncatedResponses])
+	return strings.Join(diagnostics, ", ")
+}
+
+// Whether responses are cached, for how long, and whether with other datasources.
+func responseCacheDiagnostics(cache *responseCache) string {
+	if cache == nil {
+		return "off"
+	}
+	shared := map[bool]string{true: "yes", false: "no"}
+	return "on (no TTL: revalidated on every request), shared: " + shared[cache == sharedResponseCache]
+}
+
+// The health of the datasource, from the OPEN API's response to the health check request.
+// With verbose error messages, failures include the API error's details.
+func healthCheckResponse(apiresp *http.Response, verboseErrors bool) (string, backend.HealthStatus) {
 	// 403 Forbidden is expected because -test- is not a valid zone name.
 
 	// Not a 403 response: datasource failed.
 	if apiresp.StatusCode != 403 {
 		var rspDto OpenApiErrorRspDto
 		err := json.NewDecoder(apiresp.Body).Decode(&rspDto)
-		msg := "Unexpected status code. Datasource failed: "
-		if err != nil { // A JSON decode error. Not the expected body. Use the response status for the error message.
-			msg += apiresp.Status
-		} else {
-			msg += rspDto.Errors[0].Title
+		title := apiresp.Status
+		if err == nil {
+			title = rspDto.message()
 		}
+		msg := "Unexpected status code. Datasource failed: " + title
+		if signingErr := credentialSigningError(apiresp.StatusCode, title); signingErr != nil {
+			msg = signingErr.Error()
+		}
+		if isMaintenanceResponse(apiresp.StatusCode, rspDto) {
+			msg = ErrApiMaintenance.Error() + ". Try again later"
+		}
+		msg = withErrorDetails(msg, rspDto, verboseErrors)
 		log.DefaultLogger.Error("gtmOpenApiTest", "msg", msg)
 		return msg, backend.HealthStatusError // RETURN
 	}
 
 	// 403 response
 	var rspDto OpenApiErrorRspDto
-	err = json.NewDecoder(apiresp.Body).Decode(&rspDto)
+	err := json.NewDecoder(apiresp.Body).Decode(&rspDto)
 
 	// 403 response but not the expected body: datasource failed.
 	if err != nil {
@@ -255,11 +919,11 @@ func gtmOpenApiHealthCheck(clientSecret string, host string, accessToken string,
 	}
 
 	// 403 response with the expected body
-	errorTitle := rspDto.Errors[0].Title
+	errorTitle := rspDto.message()
 
 	// 403 response but not the expected error: datasource failed.
 	if errorTitle != "Some of the requested objects are unauthorized: [-fake-]" {
-		msg := "Unexpected error type. Datasource failed: " + errorTitle
+		msg := withErrorDetails("Unexpected error type. Datasource failed: "+errorTitle, rspDto, verboseErrors)
 		log.DefaultLogger.Error("gtmOpenApiTest", "msg", msg)
 		return msg, backend.HealthStatusError // RETURN
 	}
@@ -268,48 +932,333 @@ func gtmOpenApiHealthCheck(clientSecret string, host string, accessToken string,
 }
 
 // Get data needed to populate the graph.
-func gtmOpenApiQuery(zoneNamesList []string, fromRounded time.Time, toRounded time.Time, interval Interval,
-	clientSecret string, host string, accessToken string, clientToken string) (*GtmDnsTrafficAllPropertiesRspDto, error) {
-	reqDto := NewGtmDnsTrafficAllPropertiesReqDto(zoneNamesList)   // the POST body
+func gtmOpenApiQuery(ctx context.Context, settings openApiSettings, zoneNamesList []string, metrics []string,
+	fromRounded time.Time, toRounded time.Time, interval Interval) (*GtmDnsTrafficAllPropertiesRspDto, error) {
+	reqDto := NewGtmDnsTrafficAllPropertiesReqDto(zoneNamesList, metrics) // the POST body
+	mapRequestMetrics(reqDto, settings.fieldMapping)
+	rspDto, err := gtmOpenApiQueryBody(ctx, settings, reqDto, fromRounded, toRounded, interval)
+	if rspDto != nil {
+		rspDto.unmapFields(settings.fieldMapping, reqDto.Metrics, contextLogger(ctx))
+	}
+	return rspDto, err
+}
+
+// Request the metrics by their API names, for metrics the API renamed, e.g. {"hits": "requests"}.
+func mapRequestMetrics(reqDto *GtmDnsTrafficAllPropertiesReqDto, fieldMapping map[string]string) {
+	for i, metric := range reqDto.Metrics {
+		if field, ok := fieldMapping[metric]; ok {
+			reqDto.Metrics[i] = field
+		}
+	}
+}
+
+// Rename the response's renamed fields back to the plugin's metric names. Only the requested fields, by their API names,
+// are expected in the response: a requested field missing from it is logged, as the API may have renamed it again.
+func (rspDto *GtmDnsTrafficAllPropertiesRspDto) unmapFields(fieldMapping map[string]string, requestedFields []string, logger log.Logger) {
+	requested := make(map[string]bool, len(requestedFields))
+	for _, field := range requestedFields {
+		requested[field] = true
+	}
+	for metric, field := range fieldMapping {
+		if !requested[field] {
+			continue
+		}
+		missing := false
+		for i := range rspDto.Data {
+			d := &rspDto.Data[i]
+			value, ok := d.Metrics[field]
+			if !ok {
+				missing = true
+				continue
+			}
+			delete(d.Metrics, field)
+			if metric == START_DATE_TIME_METRIC {
+				d.StartDateTime = value
+			} else {
+				d.Metrics[metric] = value
+			}
+		}
+		if missing {
+			logger.Warn("unmapFields", "metric", metric, "missing field", field)
+		}
+	}
+}
+
+// The request for the POST body, e.g. for the query inspector: the URL, then the body. It contains no credentials.
+func openApiRequestString(reqDto *GtmDnsTrafficAllPropertiesReqDto, fromRounded time.Time, toRounded time.Time, interval Interval) string {
+	postBodyJson, _ := json.Marshal(reqDto)
+	return "POST " + createPostOpenUrl(fromRounded, toRounded, interval) + "\n" + string(postBodyJson)
+}
+
+// A response cut short, e.g. by a transient network problem. Unlike a malformed response, retrying may fix it.
+var errTruncatedResponse = errors.New("Truncated response")
+
+// The response is larger than maxResponseBytes.
+var errResponseTooLarge = errors.New("Response too large")
+
+// Get data for the POST body. A truncated response is optionally retried, waiting longer before each retry.
+// Retries stop after MAX_RETRIES, or when the next would start, or run, after the retry policy's total duration.
+// The query then fails with the last error.
+func gtmOpenApiQueryBody(ctx context.Context, settings openApiSettings, reqDto *GtmDnsTrafficAllPropertiesReqDto,
+	fromRounded time.Time, toRounded time.Time, interval Interval) (*GtmDnsTrafficAllPropertiesRspDto, error) {
+	logger := contextLogger(ctx)
+	start := timeNow()
+	retryCtx, cancel := context.WithDeadline(ctx, start.Add(settings.retryPolicy.maxTotal))
+	defer cancel()
+
+	rspDto, err := gtmOpenApiQueryBodyOnce(ctx, settings, reqDto, fromRounded, toRounded, interval)
+	for retry := 0; retry < MAX_RETRIES && errors.Is(err, errTruncatedResponse) && settings.retryTruncatedResponses; retry++ {
+		if waitErr := settings.retryPolicy.wait(ctx, start, retry); waitErr != nil {
+			logger.Warn("gtmOpenApiQuery", "retry", retry, "stop", waitErr)
+			break
+		}
+		logger.Warn("gtmOpenApiQuery", "retry", retry, "err", err)
+		retryRspDto, retryErr := gtmOpenApiQueryBodyOnce(retryCtx, settings, reqDto, fromRounded, toRounded, interval)
+		if retryCtx.Err() != nil && ctx.Err() == nil { // the retry ran out of time
+			logger.Warn("gtmOpenApiQuery", "retry", retry, "stop", retryErr)
+			break
+		}
+		rspDto, err = retryRspDto, retryErr
+	}
+	return rspDto, err
+}
+
+// One attempt, within the request timeout for its rows, if any.
+func gtmOpenApiQueryBodyOnce(ctx context.Context, settings openApiSettings, reqDto *GtmDnsTrafficAllPropertiesReqDto,
+	fromRounded time.Time, toRounded time.Time, interval Interval) (*GtmDnsTrafficAllPropertiesRspDto, error) {
+	rows := estimateDataRows(fromRounded, toRounded, interval)
+	timeout := settings.requestTimeout.forRows(rows)
+	if timeout == 0 {
+		return gtmOpenApiQueryBodyRequest(ctx, settings, reqDto, fromRounded, toRounded, interval)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	rspDto, err := gtmOpenApiQueryBodyRequest(timeoutCtx, settings, reqDto, fromRounded, toRounded, interval)
+	if err != nil && timeoutCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		contextLogger(ctx).Warn("gtmOpenApiQuery", "timeout", timeout, "rows", rows, "err", err)
+		err = fmt.Errorf("Request timed out after %v (about %v rows). Narrow the time range or raise maxRequestTimeout", timeout, rows)
+	}
+	return rspDto, err
+}
+
+func gtmOpenApiQueryBodyRequest(ctx context.Context, settings openApiSettings, reqDto *GtmDnsTrafficAllPropertiesReqDto,
+	fromRounded time.Time, toRounded time.Time, interval Interval) (*GtmDnsTrafficAllPropertiesRspDto, error) {
+	logger := contextLogger(ctx)
+
 	openurl := createPostOpenUrl(fromRounded, toRounded, interval) // the POST URL
-	log.DefaultLogger.Info("gtmOpenApiQuery", "openurl", openurl)
+	logger.Debug("gtmOpenApiQuery", "openurl", openurl)
 
 	// POST to the OPEN API
 	postBodyJson, err := json.Marshal(reqDto)
 	if err != nil {
-		log.DefaultLogger.Error("Error marshaling POST request JSON", "err", err)
+		logger.Error("Error marshaling POST request JSON", "err", err)
 		return nil, err
 	}
-	config := NewEdgegridConfig(clientSecret, host, accessToken, clientToken)
 
-	apireq, err := client.NewRequest(*config, "POST", openurl, bytes.NewBuffer(postBodyJson))
-	if err != nil {
-		log.DefaultLogger.Error("Error creating POST request", "err", err)
-		return nil, err
+	// If the same request was made before, only download the response again if it changed.
+	cacheKey := responseCacheKey(credentialFingerprint(settings.host, settings.accessToken, settings.clientToken, settings.clientSecret), "POST", openurl, postBodyJson)
+	var cached cachedResponse
+	isCached := false
+	if !settings.bypassCache {
+		cached, isCached = settings.responseCache.get(cacheKey)
+	}
+	var header http.Header
+	if isCached {
+		header = cached.conditionalHeader()
 	}
-	apiresp, err := client.Do(*config, apireq)
+
+	requestStart := time.Now()
+	apiresp, err := sendOpenApiRequest(ctx, settings, "POST", openurl, postBodyJson, header)
+	settings.stats.addRequest(time.Since(requestStart))
 	if err != nil {
-		log.DefaultLogger.Error("OPEN API communication error", "err", err)
 		return nil, err
 	}
 	defer apiresp.Body.Close()
-	log.DefaultLogger.Info("gtmOpenApiQuery", "Status", apiresp.Status)
+	logger.Debug("gtmOpenApiQuery", "Status", apiresp.Status)
 
 	// OPEN API error response
-	if apiresp.StatusCode != 200 {
+	if apiresp.StatusCode != 200 && !(apiresp.StatusCode == 304 && isCached) {
 		var rspDto OpenApiErrorRspDto // the expected "error" response body
 		err := json.NewDecoder(apiresp.Body).Decode(&rspDto)
 		if err != nil { // A JSON decode error. Not the expected body. Use the response status for the error message.
 			err = errors.New(apiresp.Status)
+		} else if title := rspDto.message(); strings.HasPrefix(title, ErrUnauthorizedObjects.Error()+": ") {
+			// E.g. "Some of the requested objects are unauthorized: [foo.bar.com]"
+			err = fmt.Errorf("%w: %v", ErrUnauthorizedObjects, strings.TrimPrefix(title, ErrUnauthorizedObjects.Error()+": "))
 		} else {
-			err = errors.New(rspDto.Errors[0].Title) // E.g. "Some of the requested objects are unauthorized: [foo.bar.com]"
+			err = errors.New(title)
+		}
+		if signingErr := credentialSigningError(apiresp.StatusCode, err.Error()); signingErr != nil {
+			err = signingErr
 		}
-		log.DefaultLogger.Info("gtmOpenApiQuery", "err", err)
+		if isMaintenanceResponse(apiresp.StatusCode, rspDto) {
+			err = ErrApiMaintenance
+		}
+		if details := rspDto.details(); settings.verboseErrors && len(details) > 0 {
+			err = fmt.Errorf("%w (%v)", err, details)
+		}
+		logger.Debug("gtmOpenApiQuery", "err", err)
+		return nil, err
+	}
+
+	// OPEN API normal response, or unchanged since the cached response
+	body := cached.body
+	if apiresp.StatusCode == 200 {
+		// Read one byte more than allowed, to tell a response of the maximum size from a larger one.
+		body, err = ioutil.ReadAll(io.LimitReader(apiresp.Body, settings.maxResponseBytes+1))
+		if errors.Is(err, io.ErrUnexpectedEOF) { // the connection closed before the end of the body
+			err = fmt.Errorf("%w: %v", errTruncatedResponse, err)
+		}
+		if err != nil {
+			logger.Error("Error reading response", "err", err)
+			return nil, err
+		}
+		// Too large isn't truncated: the same response would be too large again.
+		if int64(len(body)) > settings.maxResponseBytes {
+			err := fmt.Errorf("%w: over %v bytes. Narrow the time range or reduce the number of metrics", errResponseTooLarge, settings.maxResponseBytes)
+			logger.Error("gtmOpenApiQuery", "err", err)
+			return nil, err
+		}
+	} else {
+		logger.Debug("gtmOpenApiQuery", "cache", "not modified")
+	}
+	settings.responseCache.record(apiresp.StatusCode != 200)
+	settings.stats.addCacheResult(apiresp.StatusCode != 200)
+	rspDto, err := decodeGtmDnsTrafficAllPropertiesRspDto(bytes.NewReader(body), logger)
+	if errors.Is(err, io.ErrUnexpectedEOF) { // the JSON ends early
+		err = fmt.Errorf("%w: %v", errTruncatedResponse, err)
+	}
+	if err != nil {
+		logger.Error("Error decoding response", "err", err)
 		return nil, err
 	}
+	rspDto.RawBody = body
+	// Only cache a response that could be decoded.
+	if apiresp.StatusCode == 200 {
+		settings.responseCache.put(cacheKey, apiresp.Header, body)
+	}
+
+	if mismatch := objectIdsMismatch(reqDto.ObjectIds, rspDto.Metadata.ObjectIds); len(mismatch) > 0 {
+		logger.Warn("gtmOpenApiQuery", "mismatch", mismatch)
+	}
 
-	// OPEN API normal response
-	var rspDto GtmDnsTrafficAllPropertiesRspDto // the POST response body
-	json.NewDecoder(apiresp.Body).Decode(&rspDto)
-	return &rspDto, nil
+	// A valid query, but no data, e.g. a new zone or a quiet period.
+	if len(rspDto.Data) == 0 {
+		logger.Debug("gtmOpenApiQuery", "err", ErrNoData)
+		return rspDto, ErrNoData
+	}
+	return rspDto, nil
+}
+
+// When the zone's most recent complete data ends, from the metadata of a query for the last hour.
+func gtmOpenApiAvailableDataEnds(ctx context.Context, settings openApiSettings, zone string) (time.Time, error) {
+	probeTo, err := roundupTimeForInterval(timeNow(), FIVE_MINUTES, ROUND_FLOOR)
+	if err != nil {
+		return time.Time{}, err
+	}
+	probeFrom := probeTo.Add(-time.Hour)
+
+	rspDto, err := gtmOpenApiQuery(ctx, settings, []string{zone}, []string{DEFAULT_METRIC}, probeFrom, probeTo, FIVE_MINUTES)
+	if err != nil && !errors.Is(err, ErrNoData) {
+		return time.Time{}, err
+	}
+	availableDataEnds, err := time.Parse(time.RFC3339, rspDto.Metadata.AvailableDataEnds)
+	if err != nil {
+		contextLogger(ctx).Error("gtmOpenApiAvailableDataEnds", "availableDataEnds", rspDto.Metadata.AvailableDataEnds, "err", err)
+		return time.Time{}, errors.New("The API didn't report when the available data ends")
+	}
+	return availableDataEnds, nil
+}
+
+// The response size probe asks for this much FIVE_MINUTES data of every metric: a moderately large response.
+const RESPONSE_SIZE_PROBE_RANGE = 24 * time.Hour
+
+// A warning if a moderately large response for the zone doesn't decode fully, e.g. because maxResponseBytes is too small, else "".
+// Fully decoded, the response has the number of rows its metadata reports.
+func gtmOpenApiResponseSizeProbe(ctx context.Context, settings openApiSettings, zone string) string {
+	logger := contextLogger(ctx)
+	probeTo, err := roundupTimeForInterval(timeNow(), FIVE_MINUTES, ROUND_FLOOR)
+	if err != nil {
+		return ""
+	}
+	probeFrom := probeTo.Add(-RESPONSE_SIZE_PROBE_RANGE)
+
+	var metrics []string
+	for _, metric := range supportedMetrics[FPDOMAIN_OBJECT_TYPE] {
+		metrics = append(metrics, metric.Name)
+	}
+	settings.bypassCache = true // a cached response would pass without the body being read
+	rspDto, err := gtmOpenApiQuery(ctx, settings, []string{zone}, metrics, probeFrom, probeTo, FIVE_MINUTES)
+	logger.Info("gtmOpenApiResponseSizeProbe", "zone", zone, "err", err)
+	switch {
+	case errors.Is(err, errResponseTooLarge):
+		return fmt.Sprintf("Warning: a day of %v data is over the %v byte maximum response size. Raise maxResponseBytes",
+			zone, settings.maxResponseBytes)
+	case errors.Is(err, errTruncatedResponse):
+		return fmt.Sprintf("Warning: a day of %v data arrived truncated (%v). Raise maxResponseBytes if this recurs", zone, err)
+	case errors.Is(err, ErrNoData):
+		return "" // nothing to check
+	case err != nil:
+		return "Warning: the response size probe failed: " + err.Error()
+	case len(rspDto.Data) != rspDto.Metadata.RowCount:
+		return fmt.Sprintf("Warning: a day of %v data decoded %v of the %v rows the API reported. Raise maxResponseBytes",
+			zone, len(rspDto.Data), rspDto.Metadata.RowCount)
+	}
+	return ""
+}
+
+// Long time ranges may return more rows than the OPEN API allows in a response.
+// Split the time range into windows of at most 'window' (0: don't split), query each window in order,
+// and concatenate the data. Rows repeated at window boundaries are only kept once.
+// Summary statistics can't be combined across windows, so are only kept if there's one window.
+// Each window's request, reqDto with the window's times, is appended to sentRequests, e.g. for the query inspector.
+func gtmOpenApiQueryInWindows(reqDto *GtmDnsTrafficAllPropertiesReqDto, fromRounded time.Time, toRounded time.Time, interval Interval,
+	window time.Duration, sentRequests *[]string, logger log.Logger,
+	queryWindow func(windowFrom time.Time, windowTo time.Time) (*GtmDnsTrafficAllPropertiesRspDto, error)) (*GtmDnsTrafficAllPropertiesRspDto, error) {
+
+	// Windows start and end on interval boundaries.
+	if step := interval.Duration(); step > 0 {
+		window = window / step * step
+	}
+	if window <= 0 || toRounded.Sub(fromRounded) <= window {
+		*sentRequests = append(*sentRequests, openApiRequestString(reqDto, fromRounded, toRounded, interval))
+		return queryWindow(fromRounded, toRounded)
+	}
+
+	var combined *GtmDnsTrafficAllPropertiesRspDto
+	seen := make(map[string]bool) // StartDateTime of the rows so far
+	for windowFrom := fromRounded; windowFrom.Before(toRounded); windowFrom = windowFrom.Add(window) {
+		windowTo := windowFrom.Add(window)
+		if windowTo.After(toRounded) {
+			windowTo = toRounded
+		}
+		logger.Debug("gtmOpenApiQueryInWindows", "from", windowFrom, "to", windowTo)
+
+		*sentRequests = append(*sentRequests, openApiRequestString(reqDto, windowFrom, windowTo, interval))
+		rspDto, err := queryWindow(windowFrom, windowTo)
+		if errors.Is(err, ErrNoData) {
+			continue // other windows may have data
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if combined == nil {
+			combined = &GtmDnsTrafficAllPropertiesRspDto{Metadata: rspDto.Metadata}
+		}
+		for _, datum := range rspDto.Data {
+			if !seen[datum.StartDateTime] {
+				seen[datum.StartDateTime] = true
+				combined.Data = append(combined.Data, datum)
+			}
+		}
+		combined.Metadata.End = rspDto.Metadata.End
+		combined.Metadata.AvailableDataEnds = rspDto.Metadata.AvailableDataEnds
+	}
+	if combined == nil {
+		return &GtmDnsTrafficAllPropertiesRspDto{}, ErrNoData
+	}
+	combined.Metadata.RowCount = len(combined.Data)
+	return combined, nil
 }
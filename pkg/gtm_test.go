@@ -0,0 +1,551 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// A canned OPEN API response.
+type cannedResponse struct {
+	status int
+	body   string
+}
+
+// An OPEN API server that answers every request with the response, counting the requests.
+func newTestServer(t *testing.T, response cannedResponse, requests *int32) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "EG1-HMAC-SHA256 ") {
+			t.Errorf("request not signed: %q", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(response.status)
+		w.Write([]byte(response.body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// The settings of a datasource using the server's OPEN API.
+func newTestApi(t *testing.T, response cannedResponse, requests *int32) openApiSettings {
+	return newServerApi(t, newTestServer(t, response, requests))
+}
+
+// The settings of a datasource using the server as its OPEN API.
+func newServerApi(t *testing.T, server *httptest.Server) openApiSettings {
+	dss := dataSourceSettingsJson{
+		Host:         strings.TrimPrefix(server.URL, "https://"),
+		ClientSecret: "secret",
+		AccessToken:  "akab-access-token",
+		ClientToken:  "akab-client-token",
+	}
+	settings, err := newOpenApiSettings(dss, &instanceSettings{httpClient: server.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return settings
+}
+
+const (
+	hitsBody = `{"metadata": {"interval": "FIVE_MINUTES", "objectIds": ["example.akadns.net"]},
+		"data": [{"startdatetime": "1600000000000", "hits": "12"}, {"startdatetime": "1600000300000", "hits": "N/A"}]}`
+	unauthorizedBody = `{"title": "Forbidden", "errors": [{"title": "Some of the requested objects are unauthorized: [example.akadns.net]"}]}`
+	healthCheckBody  = `{"title": "Forbidden", "errors": [{"title": "Some of the requested objects are unauthorized: [-fake-]"}]}`
+	rateLimitedBody  = `{"title": "Too Many Requests", "type": "/reporting-api/error-types/too-many-requests"}`
+)
+
+func TestGtmOpenApiQuery(t *testing.T) {
+	from := time.Unix(1600000000, 0)
+	tests := []struct {
+		name     string
+		response cannedResponse
+		wantErr  error  // matched with errors.Is
+		wantMsg  string // contained in the error
+		wantHits []*float64
+	}{
+		{name: "data", response: cannedResponse{200, hitsBody}, wantHits: []*float64{floatPtr(12), floatPtr(0)}},
+		{name: "no data", response: cannedResponse{200, `{"data": []}`}, wantErr: ErrNoData},
+		{name: "unauthorized zone", response: cannedResponse{403, unauthorizedBody}, wantErr: ErrUnauthorizedObjects},
+		{name: "rate limited", response: cannedResponse{429, rateLimitedBody}, wantMsg: "Too Many Requests"},
+		{name: "server error", response: cannedResponse{500, "Internal Server Error"}, wantMsg: "500 Internal Server Error"},
+		{name: "maintenance", response: cannedResponse{503, `{"title": "Service Unavailable"}`}, wantErr: ErrApiMaintenance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			settings := newTestApi(t, tt.response, &requests)
+			rspDto, err := gtmOpenApiQuery(context.Background(), settings, []string{"example.akadns.net"}, []string{"hits"},
+				from, from.Add(10*time.Minute), FIVE_MINUTES)
+
+			if requests != 1 {
+				t.Errorf("requests = %v, want 1", requests)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(tt.wantMsg) > 0 && (err == nil || !strings.Contains(err.Error(), tt.wantMsg)) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.wantMsg)
+			}
+			if tt.wantHits == nil {
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			zd, err := newZoneData("example.akadns.net", rspDto, []string{"hits"}, false, FIVE_MINUTES, log.DefaultLogger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame := wideFrame([]*zoneData{zd}, []string{"hits"}, "", "time", false, false, false)
+			if len(frame.Fields) != 2 || frame.Fields[1].Name != "hits" {
+				t.Fatalf("fields = %v, want time and hits", frame.Fields)
+			}
+			if got := frame.Fields[0].At(0).(time.Time); !got.Equal(from) {
+				t.Errorf("time = %v, want %v", got, from)
+			}
+			for i, want := range tt.wantHits {
+				if got := frame.Fields[1].At(i).(*float64); !floatPtrEqual(got, want) {
+					t.Errorf("hits[%v] = %v, want %v", i, formatFloatPtr(got), formatFloatPtr(want))
+				}
+			}
+		})
+	}
+}
+
+func TestGtmOpenApiQueryBodyRetries(t *testing.T) {
+	from := time.Unix(1600000000, 0)
+	tests := []struct {
+		name         string
+		response     cannedResponse
+		retry        bool
+		wantRequests int32
+		wantErr      error
+	}{
+		{name: "truncated, not retried", response: cannedResponse{200, `{"data": [`}, wantRequests: 1, wantErr: errTruncatedResponse},
+		{name: "truncated, retried", response: cannedResponse{200, `{"data": [`}, retry: true, wantRequests: 1 + MAX_RETRIES, wantErr: errTruncatedResponse},
+		{name: "server error, not retried", response: cannedResponse{500, "Internal Server Error"}, retry: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			settings := newTestApi(t, tt.response, &requests)
+			settings.retryTruncatedResponses = tt.retry
+			settings.retryPolicy = retryPolicy{maxDelay: 0, maxTotal: time.Minute} // retry without waiting
+
+			reqDto := NewGtmDnsTrafficAllPropertiesReqDto([]string{"example.akadns.net"}, []string{"hits"})
+			_, err := gtmOpenApiQueryBody(context.Background(), settings, reqDto, from, from.Add(10*time.Minute), FIVE_MINUTES)
+			if err == nil {
+				t.Fatal("err = nil, want an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("requests = %v, want %v", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestGtmOpenApiHealthCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		response   cannedResponse
+		wantStatus backend.HealthStatus
+		wantMsg    string
+	}{
+		{name: "working", response: cannedResponse{403, healthCheckBody}, wantStatus: backend.HealthStatusOk, wantMsg: "Data source is working"},
+		{name: "other 403", response: cannedResponse{403, unauthorizedBody}, wantStatus: backend.HealthStatusError, wantMsg: "Unexpected error type"},
+		{name: "rate limited", response: cannedResponse{429, rateLimitedBody}, wantStatus: backend.HealthStatusError, wantMsg: "Too Many Requests"},
+		{name: "server error", response: cannedResponse{500, "Internal Server Error"}, wantStatus: backend.HealthStatusError, wantMsg: "500 Internal Server Error"},
+		{name: "bad signature", response: cannedResponse{401, `{"title": "The signature does not match"}`}, wantStatus: backend.HealthStatusError, wantMsg: "Check the client secret"},
+		{name: "unexpected success", response: cannedResponse{200, hitsBody}, wantStatus: backend.HealthStatusError, wantMsg: "Unexpected status code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			settings := newTestApi(t, tt.response, &requests)
+			msg, status := gtmOpenApiHealthCheck(context.Background(), settings, DEFAULT_MAX_CLOCK_SKEW)
+			if status != tt.wantStatus {
+				t.Errorf("status = %v, want %v (%v)", status, tt.wantStatus, msg)
+			}
+			if !strings.Contains(msg, tt.wantMsg) {
+				t.Errorf("msg = %q, want it to contain %q", msg, tt.wantMsg)
+			}
+			if !strings.Contains(msg, "Host: "+settings.host) {
+				t.Errorf("msg = %q, want the diagnostics", msg)
+			}
+		})
+	}
+}
+
+func floatPtr(value float64) *float64 {
+	return &value
+}
+
+func floatPtrEqual(a *float64, b *float64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func formatFloatPtr(value *float64) interface{} {
+	if value == nil {
+		return "null"
+	}
+	return *value
+}
+
+func TestTestOpenUrlZoneEncoding(t *testing.T) {
+	from := time.Unix(1600000000, 0)
+	tests := []struct {
+		name string
+		zone string
+	}{
+		{name: "plain", zone: "example.akadns.net"},
+		{name: "ampersand", zone: "a&interval=HOUR"},
+		{name: "hash", zone: "a#b.akadns.net"},
+		{name: "plus and percent", zone: "a+b%20c.akadns.net"},
+		{name: "unicode", zone: "bücher.akadns.net"},
+		{name: "comma", zone: "a,b.akadns.net"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openurl := createTestOpenUrl(from, from.Add(5*time.Minute), FIVE_MINUTES, tt.zone)
+			u, err := url.Parse(openurl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := u.Query()
+			if got := query.Get("objectIds"); got != tt.zone {
+				t.Errorf("objectIds = %q, want %q", got, tt.zone)
+			}
+			// The zone can't add or replace parameters.
+			if len(query) != 4 || query.Get("interval") != string(FIVE_MINUTES) {
+				t.Errorf("query = %v, want start, end, interval FIVE_MINUTES and objectIds", query)
+			}
+		})
+	}
+}
+
+func TestStartTimeIsUTC(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+
+	tests := []struct {
+		name          string
+		local         *time.Location // the server's time zone
+		startdatetime string
+		want          time.Time
+	}{
+		{name: "UTC server", local: time.UTC, startdatetime: "1600000000000", want: time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)},
+		{name: "server east of UTC", local: time.FixedZone("UTC+5:30", 5*3600+1800), startdatetime: "1600000000000", want: time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)},
+		{name: "server west of UTC", local: time.FixedZone("UTC-8", -8*3600), startdatetime: "1600000000250", want: time.Date(2020, 9, 13, 12, 26, 40, 250e6, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			time.Local = tt.local
+			d := Datum{StartDateTime: tt.startdatetime}
+			got, err := d.startTime()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Location() != time.UTC {
+				t.Errorf("location = %v, want UTC", got.Location())
+			}
+			if !got.Equal(tt.want) || got.String() != tt.want.String() {
+				t.Errorf("startTime = %v, want %v", got, tt.want)
+			}
+
+			zd := decodeZoneData(t, "example.akadns.net", hitsBody, []string{"hits"})
+			for _, st := range zd.sampletime {
+				if st.Location() != time.UTC {
+					t.Errorf("sample time %v: location = %v, want UTC", st, st.Location())
+				}
+			}
+		})
+	}
+}
+
+func TestObjectIdsMismatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		returned  []string
+		wantMatch bool
+	}{
+		{name: "same", requested: []string{"example.akadns.net"}, returned: []string{"example.akadns.net"}, wantMatch: true},
+		{name: "canonical case", requested: []string{"Example.AKADNS.net"}, returned: []string{"example.akadns.net"}, wantMatch: true},
+		{name: "mixed case, reordered", requested: []string{"a.akadns.net", "B.akadns.net"}, returned: []string{"b.AKADNS.net", "A.akadns.net"}, wantMatch: true},
+		{name: "none returned", requested: []string{"example.akadns.net"}, wantMatch: true},
+		{name: "misspelled", requested: []string{"exmaple.akadns.net"}, returned: []string{"example.akadns.net"}},
+		{name: "missing", requested: []string{"a.akadns.net", "b.akadns.net"}, returned: []string{"A.akadns.net"}},
+		{name: "extra", requested: []string{"a.akadns.net"}, returned: []string{"a.akadns.net", "b.akadns.net"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mismatch := objectIdsMismatch(tt.requested, tt.returned)
+			if (mismatch == "") != tt.wantMatch {
+				t.Errorf("objectIdsMismatch(%q, %q) = %q, want a match: %v", tt.requested, tt.returned, mismatch, tt.wantMatch)
+			}
+		})
+	}
+}
+
+func TestChooseIntervalFourWeeks(t *testing.T) {
+	from := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	const maxDataPoints = 100000 // enough for four weeks of FIVE_MINUTES
+	withMargin := func(margin time.Duration) intervalThresholds {
+		thresholds := defaultIntervalThresholds
+		thresholds.hourOnlyMargin = margin
+		return thresholds
+	}
+	tests := []struct {
+		name       string
+		length     time.Duration
+		thresholds intervalThresholds
+		want       Interval
+	}{
+		{name: "well under", length: 7 * 24 * time.Hour, thresholds: defaultIntervalThresholds, want: FIVE_MINUTES},
+		{name: "at the margin", length: (FOUR_WEEKS - 1) * time.Hour, thresholds: defaultIntervalThresholds, want: FIVE_MINUTES},
+		{name: "within the margin", length: (FOUR_WEEKS-1)*time.Hour + time.Minute, thresholds: defaultIntervalThresholds, want: HOUR},
+		{name: "four weeks", length: FOUR_WEEKS * time.Hour, thresholds: defaultIntervalThresholds, want: HOUR},
+		{name: "over", length: (FOUR_WEEKS + 1) * time.Hour, thresholds: defaultIntervalThresholds, want: HOUR},
+		{name: "no margin, four weeks", length: FOUR_WEEKS * time.Hour, thresholds: withMargin(0), want: FIVE_MINUTES},
+		{name: "no margin, just over", length: FOUR_WEEKS*time.Hour + time.Second, thresholds: withMargin(0), want: HOUR},
+		{name: "6h margin, at the margin", length: (FOUR_WEEKS - 6) * time.Hour, thresholds: withMargin(6 * time.Hour), want: FIVE_MINUTES},
+		{name: "6h margin, within it", length: (FOUR_WEEKS - 5) * time.Hour, thresholds: withMargin(6 * time.Hour), want: HOUR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := chooseInterval(from, from.Add(tt.length), maxDataPoints, tt.thresholds)
+			if got != tt.want {
+				t.Errorf("chooseInterval(%v) = %v (%v), want %v", tt.length, got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntervalBuckets(t *testing.T) {
+	from := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		interval Interval
+		length   time.Duration
+		want     uint
+	}{
+		{interval: FIVE_MINUTES, length: time.Hour, want: 12},
+		{interval: FIVE_MINUTES, length: time.Hour + time.Second, want: 13}, // a partial interval counts
+		{interval: FIVE_MINUTES, length: time.Minute, want: 1},
+		{interval: FIVE_MINUTES, length: 0, want: 0},
+		{interval: FIVE_MINUTES, length: -time.Hour, want: 0},
+		{interval: HOUR, length: 24 * time.Hour, want: 24},
+		{interval: HOUR, length: 90 * time.Minute, want: 2},
+	}
+	for _, tt := range tests {
+		if got := tt.interval.buckets(from, from.Add(tt.length)); got != tt.want {
+			t.Errorf("%v.buckets(%v) = %v, want %v", tt.interval, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestChooseIntervalMaxDataPoints(t *testing.T) {
+	from := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	withFillRatio := func(ratio float64) intervalThresholds {
+		thresholds := defaultIntervalThresholds
+		thresholds.hourlyFillRatio = ratio
+		return thresholds
+	}
+	tests := []struct {
+		name          string
+		length        time.Duration
+		maxDataPoints uint
+		thresholds    intervalThresholds
+		want          Interval
+	}{
+		{name: "fits", length: 24 * time.Hour, maxDataPoints: 288, thresholds: defaultIntervalThresholds, want: FIVE_MINUTES},
+		{name: "overfills", length: 24 * time.Hour, maxDataPoints: 287, thresholds: defaultIntervalThresholds, want: HOUR},
+		{name: "partial interval overfills", length: 24*time.Hour + time.Minute, maxDataPoints: 288, thresholds: defaultIntervalThresholds, want: HOUR},
+		{name: "no max datapoints", length: time.Hour, maxDataPoints: 0, thresholds: defaultIntervalThresholds, want: HOUR},
+		{name: "half fill ratio, fits", length: 12 * time.Hour, maxDataPoints: 288, thresholds: withFillRatio(0.5), want: FIVE_MINUTES},
+		{name: "half fill ratio, overfills", length: 24 * time.Hour, maxDataPoints: 288, thresholds: withFillRatio(0.5), want: HOUR},
+		{name: "double fill ratio", length: 48 * time.Hour, maxDataPoints: 288, thresholds: withFillRatio(2), want: FIVE_MINUTES},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := chooseInterval(from, from.Add(tt.length), tt.maxDataPoints, tt.thresholds)
+			if got != tt.want {
+				t.Errorf("chooseInterval(%v, %v) = %v (%v), want %v", tt.length, tt.maxDataPoints, got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdjustQueryTimesRetention(t *testing.T) {
+	now := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	defer func(clock func() time.Time) { timeNow = clock }(timeNow)
+
+	oldest := now.Add(-NINETY_DAYS)
+	tests := []struct {
+		name        string
+		now         time.Time // default: now
+		from        time.Time
+		to          time.Time // default: now
+		maxLookback time.Duration
+		noClamp     bool
+		wantFrom    time.Time
+		wantErr     string
+	}{
+		{name: "just inside", from: oldest.Add(time.Hour), maxLookback: NINETY_DAYS, wantFrom: oldest.Add(time.Hour)},
+		{name: "exactly at", from: oldest, maxLookback: NINETY_DAYS, wantFrom: oldest},
+		{name: "just outside", from: oldest.Add(-time.Hour), maxLookback: NINETY_DAYS, wantFrom: oldest},
+		{name: "far outside", from: oldest.Add(-30 * 24 * time.Hour), maxLookback: NINETY_DAYS, wantFrom: oldest},
+		{name: "noClamp, exactly at", from: oldest, maxLookback: NINETY_DAYS, noClamp: true, wantFrom: oldest},
+		{name: "noClamp, just outside", from: oldest.Add(-time.Hour), maxLookback: NINETY_DAYS, noClamp: true, wantErr: "Time range starts before available data"},
+		{name: "ends before the data", from: oldest.Add(-2 * time.Hour), to: oldest.Add(-time.Hour), maxLookback: NINETY_DAYS, wantErr: "Time range is before available data"},
+		{name: "maxLookback, just outside", from: now.Add(-30*24*time.Hour - time.Hour), maxLookback: 30 * 24 * time.Hour, wantFrom: now.Add(-30 * 24 * time.Hour)},
+		{name: "maxLookback, exactly at", from: now.Add(-30 * 24 * time.Hour), maxLookback: 30 * 24 * time.Hour, wantFrom: now.Add(-30 * 24 * time.Hour)},
+		// Mid-interval, the oldest data starts at the next interval.
+		{name: "clock mid-interval", now: now.Add(10 * time.Minute), from: oldest, maxLookback: NINETY_DAYS, wantFrom: oldest.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := now
+			if !tt.now.IsZero() {
+				now = tt.now
+			}
+			timeNow = func() time.Time { return now }
+			to := tt.to
+			if to.IsZero() {
+				to = now
+			}
+
+			from, _, err := adjustQueryTimes(tt.from, to, HOUR, 0, tt.maxLookback, ROUND_FLOOR, tt.noClamp, log.DefaultLogger)
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !from.Equal(tt.wantFrom) {
+				t.Errorf("from = %v, want %v", from, tt.wantFrom)
+			}
+		})
+	}
+}
+
+func TestGtmOpenApiQueryInWindows(t *testing.T) {
+	from := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Hour)
+	// A row at minutes after from, with its hits.
+	row := func(minutes int, hits string) string {
+		ms := from.Add(time.Duration(minutes)*time.Minute).UnixNano() / int64(time.Millisecond)
+		return fmt.Sprintf(`{"startdatetime": "%v", "hits": "%v"}`, ms, hits)
+	}
+	body := func(rows ...string) string {
+		return `{"metadata": {"interval": "FIVE_MINUTES"}, "data": [` + strings.Join(rows, ", ") + `]}`
+	}
+
+	tests := []struct {
+		name      string
+		windows   [2]string // the response bodies of the hour-long windows
+		wantTimes []int     // minutes after from
+		wantHits  []string
+	}{
+		{
+			name:      "boundary row in both windows",
+			windows:   [2]string{body(row(0, "1"), row(30, "2"), row(60, "3")), body(row(60, "99"), row(90, "4"))},
+			wantTimes: []int{0, 30, 60, 90},
+			wantHits:  []string{"1", "2", "3", "4"},
+		},
+		{
+			name:      "first window empty",
+			windows:   [2]string{body(), body(row(60, "3"), row(90, "4"))},
+			wantTimes: []int{60, 90},
+			wantHits:  []string{"3", "4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				window := 0
+				if r.URL.Query().Get("start") != from.Format(time.RFC3339) {
+					window = 1
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.windows[window]))
+			}))
+			defer server.Close()
+			settings := newServerApi(t, server)
+
+			reqDto := NewGtmDnsTrafficAllPropertiesReqDto([]string{"example.akadns.net"}, []string{"hits"})
+			var sentRequests []string
+			rspDto, err := gtmOpenApiQueryInWindows(reqDto, from, to, FIVE_MINUTES, time.Hour, &sentRequests, log.DefaultLogger,
+				func(windowFrom time.Time, windowTo time.Time) (*GtmDnsTrafficAllPropertiesRspDto, error) {
+					return gtmOpenApiQuery(context.Background(), settings, reqDto.ObjectIds, []string{"hits"}, windowFrom, windowTo, FIVE_MINUTES)
+				})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requests != 2 || len(sentRequests) != 2 {
+				t.Errorf("requests = %v, recorded %v, want 2", requests, len(sentRequests))
+			}
+
+			var gotTimes []int
+			var gotHits []string
+			for _, datum := range rspDto.Data {
+				start, err := datum.startTime()
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotTimes = append(gotTimes, int(start.Sub(from)/time.Minute))
+				gotHits = append(gotHits, datum.Metrics["hits"])
+			}
+			if fmt.Sprint(gotTimes) != fmt.Sprint(tt.wantTimes) || fmt.Sprint(gotHits) != fmt.Sprint(tt.wantHits) {
+				t.Errorf("rows = %v %v, want %v %v", gotTimes, gotHits, tt.wantTimes, tt.wantHits)
+			}
+			if rspDto.Metadata.RowCount != len(tt.wantTimes) {
+				t.Errorf("rowCount = %v, want %v", rspDto.Metadata.RowCount, len(tt.wantTimes))
+			}
+		})
+	}
+}
+
+func TestSendOpenApiRequestInvalidHost(t *testing.T) {
+	_, err := sendOpenApiRequestToHost(context.Background(), openApiSettings{}, "example .luna.akamaiapis.net", http.MethodPost, "/", nil, nil)
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Fatalf("err = %v, want the URL error", err)
+	}
+	if strings.Contains(err.Error(), "Credential") {
+		t.Errorf("err = %q, want no mention of credentials", err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// The correlation ID ties together the Grafana, plugin and Akamai logs for a QueryData batch.
+const DEFAULT_CORRELATION_HEADER = "X-Correlation-Id"
+
+type correlationIdKey struct{}
+
+func withCorrelationId(ctx context.Context, correlationId string) context.Context {
+	return context.WithValue(ctx, correlationIdKey{}, correlationId)
+}
+
+// Returns "" if the context has no correlation ID.
+func correlationIdFromContext(ctx context.Context) string {
+	correlationId, _ := ctx.Value(correlationIdKey{}).(string)
+	return correlationId
+}
+
+// A logger that adds the correlation ID to every log line.
+type correlatedLogger struct {
+	correlationId string
+}
+
+func (l correlatedLogger) Debug(msg string, args ...interface{}) {
+	log.DefaultLogger.Debug(msg, append(args, "correlationId", l.correlationId)...)
+}
+
+func (l correlatedLogger) Info(msg string, args ...interface{}) {
+	log.DefaultLogger.Info(msg, append(args, "correlationId", l.correlationId)...)
+}
+
+func (l correlatedLogger) Warn(msg string, args ...interface{}) {
+	log.DefaultLogger.Warn(msg, append(args, "correlationId", l.correlationId)...)
+}
+
+func (l correlatedLogger) Error(msg string, args ...interface{}) {
+	log.DefaultLogger.Error(msg, append(args, "correlationId", l.correlationId)...)
+}
+
+// The logger to use for the request: correlated if the context has a correlation ID.
+func contextLogger(ctx context.Context) log.Logger {
+	correlationId := correlationIdFromContext(ctx)
+	if len(correlationId) == 0 {
+		return log.DefaultLogger
+	}
+	return correlatedLogger{correlationId: correlationId}
+}
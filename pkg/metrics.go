@@ -0,0 +1,39 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Plugin metrics, served by Grafana's plugin metrics endpoint.
+
+// Reasons a query's time range or interval was adjusted.
+const (
+	ADJUSTED_RETENTION_CLAMP = "retention_clamp"           // the start was moved to the oldest available data
+	ADJUSTED_LONG_RANGE      = "downsample_long_range"     // HOUR was used for a time range over 4 weeks
+	ADJUSTED_MAX_DATA_POINTS = "downsample_max_datapoints" // HOUR was used because FIVE_MINUTES overfills the graph
+)
+
+var queryAdjustmentsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "akamai_gtm",
+	Name:      "query_adjustments_total",
+	Help:      "Queries whose time range was clamped to the retention window or which were downsampled, by reason.",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(queryAdjustmentsTotal)
+}
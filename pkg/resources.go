@@ -0,0 +1,127 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// The build, set when building, e.g. with -ldflags "-X main.pluginVersion=1.0.1 -X main.gitCommit=abc1234".
+// Else the version is read from the plugin.json packaged with the executable.
+var (
+	pluginVersion = ""
+	gitCommit     = "unknown"
+)
+
+const EDGEGRID_MODULE = "github.com/akamai/AkamaiOPEN-edgegrid-golang"
+
+// The plugin's resources, e.g. GET /api/datasources/<id>/resources/version
+// Resources about a datasource get its instance from the instance manager.
+func newResourceHandler(im instancemgmt.InstanceManager) backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/version", handleVersion)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/cache-stats", func(w http.ResponseWriter, req *http.Request) {
+		handleCacheStats(w, req, im)
+	})
+	return httpadapter.New(mux)
+}
+
+// The datasource's response cache hits, misses, evictions and size, to tell whether caching helps.
+func handleCacheStats(w http.ResponseWriter, req *http.Request, im instancemgmt.InstanceManager) {
+	instance, err := im.Get(httpadapter.PluginConfigFromContext(req.Context()))
+	if err != nil {
+		log.DefaultLogger.Error("handleCacheStats", "err", err)
+		http.Error(w, "Failed to get the datasource instance", http.StatusInternalServerError)
+		return
+	}
+	writeJsonResource(w, instance.(*instanceSettings).responseCache.stats())
+}
+
+// The running build, to correlate behavior with releases without shell access.
+func handleVersion(w http.ResponseWriter, req *http.Request) {
+	edgegridVersion := "unknown"
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range buildInfo.Deps {
+			if dep.Path == EDGEGRID_MODULE {
+				edgegridVersion = dep.Version
+			}
+		}
+	}
+
+	writeJsonResource(w, map[string]string{
+		"version":         builtPluginVersion(),
+		"commit":          gitCommit,
+		"edgegridVersion": edgegridVersion,
+	})
+}
+
+// The metrics of an object type, e.g. GET .../resources/metrics?objectType=fpdomain, for the query editor's metrics dropdown.
+// Default object type: fpdomain, the only one the plugin queries.
+func handleMetrics(w http.ResponseWriter, req *http.Request) {
+	objectType := req.URL.Query().Get("objectType")
+	if len(objectType) == 0 {
+		objectType = FPDOMAIN_OBJECT_TYPE
+	}
+	metrics, ok := supportedMetrics[objectType]
+	if !ok {
+		http.Error(w, "Unsupported object type: "+objectType, http.StatusBadRequest)
+		return
+	}
+	writeJsonResource(w, metrics)
+}
+
+// The plugin version set when building, else the packaged plugin.json's.
+func builtPluginVersion() string {
+	if len(pluginVersion) > 0 {
+		return pluginVersion
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return "unknown"
+	}
+	pluginJson, err := ioutil.ReadFile(filepath.Join(filepath.Dir(executable), "plugin.json"))
+	if err != nil {
+		return "unknown"
+	}
+	var plugin struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(pluginJson, &plugin); err != nil || len(plugin.Info.Version) == 0 {
+		return "unknown"
+	}
+	return plugin.Info.Version
+}
+
+func writeJsonResource(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.DefaultLogger.Error("writeJsonResource", "err", err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Retries wait longer each time, but no longer than the policy's caps, so a slow query can't hold a worker indefinitely.
+type retryPolicy struct {
+	maxDelay time.Duration // the longest wait before a retry
+	maxTotal time.Duration // the longest time from the first attempt until a retry starts
+}
+
+const (
+	RETRY_INITIAL_DELAY              = 1 * time.Second
+	DEFAULT_MAX_RETRY_DELAY          = 10 * time.Second
+	DEFAULT_MAX_TOTAL_RETRY_DURATION = 30 * time.Second
+	MAX_RETRIES                      = 5 // however short the delays, e.g. with a maxDelay of 0
+)
+
+// The retry would start after the policy's total retry duration.
+var errRetryDeadline = errors.New("Retry deadline exceeded")
+
+// The wait before the retry'th retry (0 for the first): doubling each time, at most maxDelay.
+func (p retryPolicy) delay(retry int) time.Duration {
+	delay := RETRY_INITIAL_DELAY
+	for i := 0; i < retry && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	return delay
+}
+
+// Wait before the retry'th retry of attempts that started at 'start'.
+// Fails without waiting if the retry would start after the total retry duration, or when the context is done.
+func (p retryPolicy) wait(ctx context.Context, start time.Time, retry int) error {
+	delay := p.delay(retry)
+	if timeNow().Add(delay).After(start.Add(p.maxTotal)) {
+		return errRetryDeadline
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
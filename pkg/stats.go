@@ -0,0 +1,89 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// A query's performance, logged as a single line when it ends, e.g. to find the slow queries behind timeouts.
+type queryStats struct {
+	start    time.Time
+	zones    int
+	interval Interval
+
+	mu          sync.Mutex
+	apiRequests int
+	apiLatency  time.Duration // the requests' total time until the response headers
+	cacheHits   int           // responses not modified since cached
+	cacheMisses int
+}
+
+func (s *queryStats) addRequest(latency time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiRequests++
+	s.apiLatency += latency
+}
+
+func (s *queryStats) addCacheResult(hit bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hit {
+		s.cacheHits++
+	} else {
+		s.cacheMisses++
+	}
+}
+
+// Log the query's performance and outcome. The rows are those of all the response's frames.
+func (s *queryStats) log(logger log.Logger, query backend.DataQuery, response backend.DataResponse) {
+	rows := 0
+	for _, frame := range response.Frames {
+		rows += frame.Rows()
+	}
+	errMessage := ""
+	if response.Error != nil {
+		errMessage = response.Error.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logger.Info("queryStats",
+		"refId", query.RefID,
+		"from", query.TimeRange.From,
+		"to", query.TimeRange.To,
+		"zones", s.zones,
+		"interval", s.interval,
+		"rows", rows,
+		"apiRequests", s.apiRequests,
+		"apiLatency", s.apiLatency,
+		"duration", time.Since(s.start),
+		"cacheHits", s.cacheHits,
+		"cacheMisses", s.cacheMisses,
+		"err", errMessage,
+	)
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright 2021 Akamai Technologies, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"time"
+)
+
+// A request's timeout, scaled with the rows it asks for: a 90-day HOUR query takes the API longer than a 1-hour FIVE_MINUTES one.
+type requestTimeout struct {
+	perRow time.Duration // 0: no timeout
+	min    time.Duration // the shortest timeout, however few the rows
+	max    time.Duration // the longest timeout, however many the rows
+}
+
+const (
+	DEFAULT_MIN_REQUEST_TIMEOUT = 10 * time.Second
+	DEFAULT_MAX_REQUEST_TIMEOUT = 2 * time.Minute
+)
+
+// The timeout of a request for about 'rows' rows, within the bounds. 0: no timeout.
+func (t requestTimeout) forRows(rows int) time.Duration {
+	if t.perRow <= 0 {
+		return 0
+	}
+	timeout := time.Duration(rows) * t.perRow
+	if timeout < t.min {
+		timeout = t.min
+	}
+	if timeout > t.max {
+		timeout = t.max
+	}
+	return timeout
+}
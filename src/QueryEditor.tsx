@@ -45,9 +45,21 @@ export class QueryEditor extends PureComponent<Props> {
     }
   };
 
+  onMetricsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    const metrics = event.target.value
+      .split(',')
+      .map(metric => metric.trim())
+      .filter(metric => metric);
+    onChange({ ...query, metrics: metrics });
+    if (query.domainName) {
+      onRunQuery();
+    }
+  };
+
   render() {
     const query = defaults(this.props.query, defaultQuery);
-    const { domainName, metricName } = query;
+    const { domainName, metricName, metrics } = query;
 
     return (
       <div className="gf-form">
@@ -59,7 +71,7 @@ export class QueryEditor extends PureComponent<Props> {
             placeholder="Enter domain name"
             onChange={this.onDomainNameChange}
             label="Domain"
-            tooltip="Enter one domain name."
+            tooltip="Enter one or more domain names, separated by commas. Each is graphed separately."
           />
           <FormField
             value={metricName || ''}
@@ -67,7 +79,16 @@ export class QueryEditor extends PureComponent<Props> {
             inputWidth={20}
             onChange={this.onMetricNameChange}
             label="Metric Name"
-            tooltip="Graphed metric's name. If empty, a name is generated."
+            tooltip="Graphed metric's name. If empty, the metric is used."
+          />
+          <FormField
+            value={(metrics || []).join(', ')}
+            labelWidth={8}
+            inputWidth={20}
+            placeholder="hits"
+            onChange={this.onMetricsChange}
+            label="Metrics"
+            tooltip="Enter one or more metrics, separated by commas, e.g. hits. Each is a separate field."
           />
         </div>
       </div>
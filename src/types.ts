@@ -19,6 +19,32 @@ import { DataQuery, DataSourceJsonData } from '@grafana/data';
 export interface MyQuery extends DataQuery {
   domainName?: string;
   metricName?: string;
+  zoneNames?: string[] | string;
+  metrics?: string[];
+  interval?: string;
+  timeFieldName?: string;
+  summaryOnly?: boolean;
+  frameFormat?: string;
+  raw?: boolean;
+  includeSummary?: boolean;
+  noClamp?: boolean;
+  precision?: number;
+  duplicateTimes?: string;
+  requestBody?: object;
+  anchorToAvailableData?: boolean;
+  zoneAliases?: { [zone: string]: string };
+  includeTotal?: boolean;
+  compareOffset?: string;
+  percentOfTotal?: boolean;
+  includeMetadata?: boolean;
+  valueType?: string;
+  resilient?: boolean;
+  cumulative?: boolean;
+  includeRate?: boolean;
+  noCache?: boolean;
+  includeCoverage?: boolean;
+  lastN?: number;
+  totalNA?: string;
 }
 
 export const defaultQuery: Partial<MyQuery> = {};
@@ -28,4 +54,56 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
   host?: string;
   accessToken?: string;
   clientToken?: string;
+  dataDelay?: string;
+  correlationHeader?: string;
+  partialInterval?: string;
+  partialHourInterval?: string;
+  allowedZones?: string[];
+  dropDisallowedZones?: boolean;
+  defaultZones?: string[] | string;
+  dataPointLimit?: number;
+  offlineHealthCheck?: boolean;
+  maxLookback?: string;
+  queryWindow?: string;
+  rounding?: string;
+  noDataAsError?: boolean;
+  customHeaders?: { [name: string]: string };
+  dialTimeout?: string;
+  tlsHandshakeTimeout?: string;
+  responseHeaderTimeout?: string;
+  region?: string;
+  requestsPerSecond?: number;
+  credentialSource?: string;
+  credentialSection?: string;
+  edgercPath?: string;
+  maxResponseBytes?: number;
+  responseSizeProbeZone?: string;
+  maxClockSkew?: string;
+  hourOnlyAfter?: string;
+  hourOnlyMargin?: string;
+  hourlyFillRatio?: number;
+  intervalMismatchAsError?: boolean;
+  allUnauthorizedAsNotice?: boolean;
+  retryTruncatedResponses?: boolean;
+  maxRetryDelay?: string;
+  maxTotalRetryDuration?: string;
+  requestTimeoutPerRow?: string;
+  minRequestTimeout?: string;
+  maxRequestTimeout?: string;
+  expectedDataLag?: string;
+  sharedCache?: boolean;
+  disableResponseCache?: boolean;
+  verboseErrors?: boolean;
+  disableHttp2?: boolean;
+  zeroAsNull?: boolean;
+  backupHosts?: string[];
+  failoverOn?: string;
+  fieldMapping?: { [metric: string]: string };
+  lowercaseZoneNames?: boolean;
+  circuitBreakerThreshold?: number;
+  circuitBreakerWindow?: string;
+  circuitBreakerCooldown?: string;
+  seriesNamePrefix?: string;
+  seriesNameSuffix?: string;
+  maxRangeDuration?: string;
 }